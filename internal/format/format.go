@@ -4,7 +4,9 @@ import (
 	// Standard library dependencies
 	"encoding/json"
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 	"strings"
 
 	// Internal dependencies
@@ -12,16 +14,143 @@ import (
 	"CloudCutter/models"
 )
 
+// Output formats supported by FormatEvent
+var Formats = []string{"log", "json", "jsonl"}
+
+// Validate checks that a format is supported before any events are read
+func Validate(format string) error {
+	if !slices.Contains(Formats, format) {
+		return fmt.Errorf("unknown output format '%s' (expected one of: %s)", format, strings.Join(Formats, ", "))
+	}
+
+	return nil
+}
+
 // FormatEvent formats the event based on the given format
-func FormatEvent(event models.PurviewEvent, format string) string {
+// json returns an indented object for use inside an array, jsonl a single line
+func FormatEvent(event models.PurviewEvent, format string) (string, error) {
 	switch format {
 	case "log":
-		return logFormat(event)
+		return logFormat(event), nil
+	case "json":
+		return jsonFormat(event, "  ")
+	case "jsonl":
+		return jsonFormat(event, "")
 	default:
-		return logFormat(event)
+		return "", Validate(format)
 	}
 }
 
+// JSON format, serialising the whole event including AuditData & any Sigma rule details
+func jsonFormat(event models.PurviewEvent, indent string) (string, error) {
+	var builder strings.Builder
+	encoder := json.NewEncoder(&builder)
+	encoder.SetEscapeHTML(false) // Keep message IDs such as <id@host> readable
+	if indent != "" {
+		encoder.SetIndent(indent, indent)
+	}
+
+	if err := encoder.Encode(event); err != nil {
+		return "", fmt.Errorf("failed to encode event %s as JSON: %v", event.RecordID, err)
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n"), nil
+}
+
+// FormatRow formats chosen fields of an event (--fields) or a summary row, keeping the order given
+func FormatRow(names []string, values []any, format string) (string, error) {
+	switch format {
+	case "log":
+		// Line the values up after the longest name, as logFormat does for the struct fields
+		width := 20
+		for _, name := range names {
+			width = max(width, len(name))
+		}
+
+		var builder strings.Builder
+		for index, name := range names {
+			fmt.Fprintf(&builder, "%-*s: %s\n", width, name, FormatValue(values[index]))
+		}
+		builder.WriteString("-----------------------")
+		return builder.String(), nil
+	case "json", "jsonl":
+		return jsonRow(names, values, format == "json")
+	default:
+		return "", Validate(format)
+	}
+}
+
+// FormatValue formats a single value for a table cell or CSV column
+// Arrays are joined with commas & objects written as JSON
+func FormatValue(value any) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case []any:
+		parts := make([]string, len(typed))
+		for index, element := range typed {
+			parts[index] = FormatValue(element)
+		}
+		return strings.Join(parts, ", ")
+	case []string:
+		return strings.Join(typed, ", ")
+	case map[string]any:
+		if encoded, err := encodeJSON(typed); err == nil {
+			return encoded
+		}
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// JSON object with its keys in the order given, rather than sorted as encoding a map would
+func jsonRow(names []string, values []any, indent bool) (string, error) {
+	var builder strings.Builder
+	builder.WriteString("{")
+
+	for index, name := range names {
+		key, err := encodeJSON(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode field %s as JSON: %v", name, err)
+		}
+		value, err := encodeJSON(values[index])
+		if err != nil {
+			return "", fmt.Errorf("failed to encode field %s as JSON: %v", name, err)
+		}
+
+		if index > 0 {
+			builder.WriteString(",")
+		}
+		if indent {
+			builder.WriteString("\n    ")
+			fmt.Fprintf(&builder, "%s: %s", key, value)
+		} else {
+			fmt.Fprintf(&builder, "%s:%s", key, value)
+		}
+	}
+
+	if indent && len(names) > 0 {
+		builder.WriteString("\n  ")
+	}
+	builder.WriteString("}")
+
+	return builder.String(), nil
+}
+
+// Encodes a value as compact JSON without escaping HTML characters such as <id@host>
+func encodeJSON(value any) (string, error) {
+	var builder strings.Builder
+	encoder := json.NewEncoder(&builder)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n"), nil
+}
+
 // Log format
 func logFormat(event models.PurviewEvent) string {
 	logger.Debugf("Formatting event: %s", event.RecordID)
@@ -31,7 +160,9 @@ func logFormat(event models.PurviewEvent) string {
 
 	var ignoreFields = []string{
 		"SourceFile",
+		"LogSource",
 		"Timestamp",
+		"TimestampUTC",
 		"Folders",
 		"Folder",
 		"RawData",
@@ -62,6 +193,15 @@ func logFormat(event models.PurviewEvent) string {
 			}
 			continue
 
+		case "Properties":
+			if len(event.Properties) > 0 {
+				builder.WriteString("Properties:\n")
+				for _, key := range slices.Sorted(maps.Keys(event.Properties)) {
+					fmt.Fprintf(&builder, "  %-30s: %v\n", key, event.Properties[key])
+				}
+			}
+			continue
+
 		case "Files":
 			if len(event.Files) > 0 {
 				builder.WriteString("Files:\n")
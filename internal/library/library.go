@@ -0,0 +1,170 @@
+package library
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	// Internal dependencies
+	"CloudCutter/internal/logger"
+	"CloudCutter/tools/search"
+
+	// External dependencies
+	"gopkg.in/yaml.v3"
+)
+
+// Query is a named search saved in a library file
+type Query struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description"`
+	Query       string            `yaml:"query"`
+	Parameters  map[string]string `yaml:"parameters"` // Default value of each placeholder, empty if it must be given
+	Source      string            `yaml:"-"`          // File the query was loaded from
+}
+
+// Library holds saved queries by name
+type Library struct {
+	queries map[string]Query
+}
+
+// Layout of a library file
+type file struct {
+	Queries []Query `yaml:"queries"`
+}
+
+// DefaultPath returns the directory saved queries are loaded from when it exists
+func DefaultPath() string {
+	directory, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(directory, "CloudCutter", "queries")
+}
+
+// Load reads saved queries from YAML files & directories of them, in order
+// A query with the same name as an earlier one replaces it, so later paths override the defaults
+func Load(paths []string) (*Library, error) {
+	library := &Library{queries: make(map[string]Query)}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open query library: %v", err)
+		}
+
+		files := []string{path}
+		if info.IsDir() {
+			files = nil
+			for _, pattern := range []string{"*.yml", "*.yaml"} {
+				matches, err := filepath.Glob(filepath.Join(path, pattern))
+				if err != nil {
+					return nil, fmt.Errorf("failed to read query library %s: %v", path, err)
+				}
+				files = append(files, matches...)
+			}
+			slices.Sort(files)
+		}
+
+		for _, name := range files {
+			if err := library.loadFile(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return library, nil
+}
+
+// Reads the queries from a single library file
+func (library *Library) loadFile(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read query library %s: %v", path, err)
+	}
+
+	var parsed file
+	if err := yaml.Unmarshal(contents, &parsed); err != nil {
+		return fmt.Errorf("failed to parse query library %s: %v", path, err)
+	}
+
+	for index, query := range parsed.Queries {
+		if query.Name == "" || strings.TrimSpace(query.Query) == "" {
+			return fmt.Errorf("invalid query library %s: query %d needs a name & a query", path, index+1)
+		}
+		if existing, ok := library.queries[strings.ToLower(query.Name)]; ok {
+			logger.Debugf("Saved query '%s' from %s replaces the one from %s", query.Name, path, existing.Source)
+		}
+
+		query.Source = path
+		library.queries[strings.ToLower(query.Name)] = query
+	}
+	logger.Debugf("Loaded %d saved queries from %s", len(parsed.Queries), path)
+
+	return nil
+}
+
+// Queries returns every saved query ordered by name
+func (library *Library) Queries() []Query {
+	var queries []Query
+	for _, key := range slices.Sorted(maps.Keys(library.queries)) {
+		queries = append(queries, library.queries[key])
+	}
+
+	return queries
+}
+
+// Get looks up a saved query by name, whatever its case
+func (library *Library) Get(name string) (Query, error) {
+	query, ok := library.queries[strings.ToLower(name)]
+	if !ok {
+		var names []string
+		for _, saved := range library.Queries() {
+			names = append(names, saved.Name)
+		}
+		if len(names) == 0 {
+			return Query{}, fmt.Errorf("unknown saved query '%s' (no saved queries were loaded)", name)
+		}
+		return Query{}, fmt.Errorf("unknown saved query '%s' (available: %s)", name, strings.Join(names, ", "))
+	}
+
+	return query, nil
+}
+
+// Placeholders returns the names of the placeholders in the query, in the order they first appear
+func (query Query) Placeholders() []string {
+	return search.Placeholders(query.Query)
+}
+
+// Values checks the parameters given for the query & returns the value of every placeholder, using defaults if not given
+// The values are filled in by the search parser, which keeps each one a single value whatever it contains
+func (query Query) Values(parameters map[string]string) (map[string]string, error) {
+	placeholders := query.Placeholders()
+	for name := range parameters {
+		if !slices.Contains(placeholders, name) {
+			return nil, fmt.Errorf("saved query '%s' has no parameter '%s' (parameters: %s)", query.Name, name, strings.Join(placeholders, ", "))
+		}
+	}
+
+	values := make(map[string]string, len(placeholders))
+	var missing []string
+	for _, name := range placeholders {
+		if value, ok := parameters[name]; ok {
+			values[name] = value
+		} else if value := query.Parameters[name]; value != "" {
+			values[name] = value
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("saved query '%s' needs a value for %s (e.g. --param %s=...)", query.Name, strings.Join(missing, ", "), missing[0])
+	}
+
+	return values, nil
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	// Internal dependencies
+	"CloudCutter/internal/library"
+)
+
+// PrintSavedQueries prints the saved queries in the library as a table
+func PrintSavedQueries(queries []library.Query) {
+	if len(queries) == 0 {
+		fmt.Println("No saved queries found...")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tPARAMETERS\tDESCRIPTION")
+	for _, query := range queries {
+		// Parameters with a default are shown with it
+		var parameters []string
+		for _, name := range query.Placeholders() {
+			if value := query.Parameters[name]; value != "" {
+				name += "=" + value
+			}
+			parameters = append(parameters, name)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\n",
+			query.Name,
+			strings.Join(parameters, ", "),
+			strings.Join(strings.Fields(query.Description), " "),
+		)
+	}
+	writer.Flush()
+}
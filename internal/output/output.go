@@ -4,60 +4,105 @@ import (
 	// Standard library dependencies
 	"encoding/csv"
 	"fmt"
+	"iter"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
 
 	// Internal dependencies
 	"CloudCutter/internal/format"
 	"CloudCutter/internal/parser"
 	"CloudCutter/models"
+	"CloudCutter/tools/search"
 )
 
 // ResultOptions holds configuration for processing results
 type ResultOptions struct {
-	Limit        int
-	CountOnly    bool
-	OutputFormat string
-	OutputFile   string
-	IncludeSigma bool
+	Limit          int
+	CountOnly      bool
+	OutputFormat   string
+	OutputFile     string
+	IncludeSigma   bool
+	IncludeContext bool         // Adds the ContextOf column marking events pulled in around a hit
+	IncludeMatches bool         // Adds the columns listing the fields & keywords a keyword search found
+	Sort           []SortKey    // Fields to sort the results by, which means reading them all first
+	Fields         []string     // Fields to output instead of the whole event
+	Distinct       []string     // Fields to output the unique values of instead of the events
+	Err            func() error // Reports whether reading the input failed part way through
 }
 
-// ExportToCSV writes a slice of PurviewEvents to a CSV file
-func ExportToCSV(events []models.PurviewEvent, filePath string, includeSigma bool) error {
+// csvExporter writes events to a CSV file as they are produced
+type csvExporter struct {
+	file    *os.File
+	writer  *csv.Writer
+	headers []string
+	resolve func(header string, event models.PurviewEvent) string
+}
+
+// newCSVExporter creates the output file & writes the header row
+// Each column's value is looked up with resolve
+func newCSVExporter(filePath string, headers []string, resolve func(string, models.PurviewEvent) string) (*csvExporter, error) {
 	file, err := os.Create(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %v", err)
+		return nil, fmt.Errorf("failed to create output file: %v", err)
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	exporter := &csvExporter{
+		file:    file,
+		writer:  csv.NewWriter(file),
+		headers: headers,
+		resolve: resolve,
+	}
 
-	// Get headers
-	headers := parser.GetPurviewEventColumns(events, includeSigma)
-	if err := writer.Write(headers); err != nil {
-		return fmt.Errorf("failed to write CSV headers: %v", err)
+	// Write headers
+	if err := exporter.writer.Write(exporter.headers); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write CSV headers: %v", err)
 	}
 
-	// Write data
-	for _, event := range events {
-		var row []string
-		for _, header := range headers {
-			row = append(row, resolveCSVValue(header, event))
-		}
-		if err := writer.Write(row); err != nil {
-			return fmt.Errorf("failed to write CSV row: %v", err)
-		}
+	return exporter, nil
+}
+
+// write adds a single event as a row
+func (exporter *csvExporter) write(event models.PurviewEvent) error {
+	row := make([]string, 0, len(exporter.headers))
+	for _, header := range exporter.headers {
+		row = append(row, exporter.resolve(header, event))
+	}
+
+	return exporter.writeRow(row)
+}
+
+// writeRow adds a row of values already formatted
+func (exporter *csvExporter) writeRow(row []string) error {
+	if err := exporter.writer.Write(row); err != nil {
+		return fmt.Errorf("failed to write CSV row: %v", err)
 	}
 
 	return nil
 }
 
+// close flushes any buffered rows & closes the file
+func (exporter *csvExporter) close() error {
+	exporter.writer.Flush()
+	if err := exporter.writer.Error(); err != nil {
+		exporter.file.Close()
+		return fmt.Errorf("failed to write CSV: %v", err)
+	}
+
+	return exporter.file.Close()
+}
+
 // resolveCSVValue extracts a value from a PurviewEvent based on the header name
 func resolveCSVValue(header string, event models.PurviewEvent) string {
 	// Handle complex/nested fields explicitly if needed, otherwise use map or reflection
 	if strings.Contains(header, ".") {
+		// Flattened AuditData paths, e.g. AppAccessContext.AADSessionId
+		if val, ok := event.Flattened[strings.ToLower(header)]; ok {
+			return fmt.Sprintf("%v", val)
+		}
+
 		parts := strings.Split(header, ".")
 		val := resolveRecursive(parts, event)
 		return fmt.Sprintf("%v", val)
@@ -69,6 +114,10 @@ func resolveCSVValue(header string, event models.PurviewEvent) string {
 	for i := 0; i < v.NumField(); i++ {
 		fieldType := v.Type().Field(i)
 		if strings.EqualFold(fieldType.Name, header) {
+			// Lists such as SigmaRuleTags & MatchedFields are joined the same way as with --fields
+			if list, ok := v.Field(i).Interface().([]string); ok {
+				return format.FormatValue(list)
+			}
 			return fmt.Sprintf("%v", v.Field(i).Interface())
 		}
 	}
@@ -122,37 +171,104 @@ func resolveRecursive(parts []string, data any) any {
 }
 
 // ProcessResults handles exporting to CSV and/or printing to terminal
-func ProcessResults(events []models.PurviewEvent, opts ResultOptions) error {
-	if len(events) == 0 {
-		fmt.Println("No matches found...")
-		return nil
+// Events are consumed in a single pass & reading stops once the limit is reached
+func ProcessResults(events iter.Seq[models.PurviewEvent], opts ResultOptions) error {
+	if err := format.Validate(opts.OutputFormat); err != nil {
+		return err
 	}
 
+	// Unique values replace the events altogether
+	if len(opts.Distinct) > 0 {
+		return processDistinct(events, opts)
+	}
+
+	if len(opts.Sort) > 0 {
+		events = sortEvents(events, opts.Sort)
+	}
+
+	printing := !opts.CountOnly && opts.OutputFile == ""
+	printer := newPrinter(opts.OutputFormat)
+
 	// Export to CSV if output file is specified
+	var exporter *csvExporter
 	if opts.OutputFile != "" {
-		err := ExportToCSV(events, opts.OutputFile, opts.IncludeSigma)
+		headers := parser.GetPurviewEventColumns(opts.IncludeSigma)
+		if opts.IncludeMatches {
+			headers = slices.Insert(headers, 1, "MatchedFields", "MatchedKeywords")
+		}
+		if opts.IncludeContext {
+			headers = slices.Insert(headers, 1, "ContextOf")
+		}
+		resolve := resolveCSVValue
+		if len(opts.Fields) > 0 {
+			headers = opts.Fields
+			resolve = func(header string, event models.PurviewEvent) string {
+				return format.FormatValue(search.Resolve(event, header))
+			}
+		}
+
+		var err error
+		exporter, err = newCSVExporter(opts.OutputFile, headers, resolve)
 		if err != nil {
 			return fmt.Errorf("error exporting to CSV: %v", err)
 		}
-		fmt.Printf("Successfully exported %d events to %s\n", len(events), opts.OutputFile)
 	}
 
-	// Output to terminal
-	printedCount := 0
-	for _, event := range events {
-		if opts.Limit > 0 && printedCount >= opts.Limit {
+	processedCount := 0
+	for event := range events {
+		if exporter != nil {
+			if err := exporter.write(event); err != nil {
+				exporter.close()
+				return fmt.Errorf("error exporting to CSV: %v", err)
+			}
+		}
+
+		// Output to terminal
+		if printing {
+			var formatted string
+			var err error
+			if len(opts.Fields) > 0 {
+				formatted, err = format.FormatRow(opts.Fields, projectEvent(event, opts.Fields), opts.OutputFormat)
+			} else {
+				formatted, err = format.FormatEvent(event, opts.OutputFormat)
+			}
+			if err != nil {
+				return err
+			}
+
+			printer.print(formatted)
+		}
+
+		processedCount++
+		if opts.Limit > 0 && processedCount >= opts.Limit {
 			break
 		}
+	}
 
-		if !opts.CountOnly && opts.OutputFile == "" {
-			fmt.Println(format.FormatEvent(event, opts.OutputFormat))
+	if exporter != nil {
+		if err := exporter.close(); err != nil {
+			return fmt.Errorf("error exporting to CSV: %v", err)
 		}
+	}
+
+	if printing {
+		printer.close()
+	}
+
+	if err := readError(opts.Err); err != nil {
+		return err
+	}
+
+	if processedCount == 0 {
+		return noMatches(opts.OutputFormat, opts.OutputFile, opts.CountOnly)
+	}
 
-		printedCount++
+	if exporter != nil {
+		fmt.Printf("Successfully exported %d events to %s\n", processedCount, opts.OutputFile)
 	}
 
 	if opts.CountOnly {
-		fmt.Println(printedCount)
+		fmt.Println(processedCount)
 	}
 
 	return nil
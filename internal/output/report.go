@@ -0,0 +1,99 @@
+package output
+
+import (
+	// Standard library dependencies
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	// Internal dependencies
+	"CloudCutter/internal/parser"
+)
+
+// ErrorReport counts skipped or partially parsed rows & optionally writes them to a CSV file
+type ErrorReport struct {
+	file    *os.File
+	writer  *csv.Writer
+	Skipped int
+	Partial int
+
+	partialRows map[string]bool // Rows already counted as partial, as one row can have several problems
+	warnings    []string        // Problems with whole exports, printed once the exports have been read
+}
+
+// NewErrorReport creates a report, writing rows to filePath if it is set
+func NewErrorReport(filePath string) (*ErrorReport, error) {
+	report := &ErrorReport{partialRows: make(map[string]bool)}
+	if filePath == "" {
+		return report, nil
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create error report: %v", err)
+	}
+
+	report.file = file
+	report.writer = csv.NewWriter(file)
+	if err := report.writer.Write([]string{"Source", "Line", "RecordID", "Status", "Reason"}); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write error report headers: %v", err)
+	}
+
+	return report, nil
+}
+
+// Add records a single problem with a row
+func (report *ErrorReport) Add(parseError parser.ParseError) {
+	status := "partial"
+	if parseError.Skipped {
+		status = "skipped"
+		report.Skipped++
+	} else if row := parseError.Source + ":" + strconv.Itoa(parseError.Line); !report.partialRows[row] {
+		report.partialRows[row] = true
+		report.Partial++
+	}
+
+	if report.writer != nil {
+		report.writer.Write([]string{
+			parseError.Source,
+			strconv.Itoa(parseError.Line),
+			parseError.RecordID,
+			status,
+			parseError.Reason,
+		})
+	}
+}
+
+// Warn records a problem with an export as a whole
+func (report *ErrorReport) Warn(message string) {
+	report.warnings = append(report.warnings, message)
+}
+
+// Close flushes the report & prints a summary of any problems to stderr
+func (report *ErrorReport) Close() error {
+	for _, warning := range report.warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if report.Skipped+report.Partial > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d rows skipped & %d rows partially parsed", report.Skipped, report.Partial)
+		if report.file != nil {
+			fmt.Fprintf(os.Stderr, " (see %s)\n", report.file.Name())
+		} else {
+			fmt.Fprintln(os.Stderr, " (use --error-report to list them or --strict to abort)")
+		}
+	}
+
+	if report.file == nil {
+		return nil
+	}
+
+	report.writer.Flush()
+	if err := report.writer.Error(); err != nil {
+		report.file.Close()
+		return fmt.Errorf("failed to write error report: %v", err)
+	}
+
+	return report.file.Close()
+}
@@ -0,0 +1,36 @@
+package output
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	// Internal dependencies
+	"CloudCutter/tools/schema"
+)
+
+// PrintSchema prints the fields observed in the events as a table
+func PrintSchema(fields []schema.Field, eventCount int) {
+	if len(fields) == 0 {
+		fmt.Println("No events found...")
+		return
+	}
+
+	fmt.Printf("Fields observed in %d events:\n", eventCount)
+	fmt.Println("-----------------------")
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "FIELD\tCOUNT\tTYPES\tWORKLOADS\tSAMPLES")
+	for _, field := range fields {
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\n",
+			field.Name,
+			field.Count,
+			strings.Join(field.Types, ","),
+			strings.Join(field.Workloads, ","),
+			strings.Join(field.Samples, " | "),
+		)
+	}
+	writer.Flush()
+}
@@ -0,0 +1,103 @@
+package output
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"strings"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/format"
+	"CloudCutter/internal/parser"
+	"CloudCutter/tools/sequence"
+)
+
+// ProcessChains prints or exports each chain found by a sequence query with its events grouped under it
+func ProcessChains(chains []sequence.Chain, join []string, opts ResultOptions) error {
+	if err := format.Validate(opts.OutputFormat); err != nil {
+		return err
+	}
+
+	if opts.Limit > 0 && len(chains) > opts.Limit {
+		chains = chains[:opts.Limit]
+	}
+
+	if err := readError(opts.Err); err != nil {
+		return err
+	}
+
+	if len(chains) == 0 {
+		return noMatches(opts.OutputFormat, opts.OutputFile, opts.CountOnly)
+	}
+
+	if opts.CountOnly {
+		fmt.Println(len(chains))
+		return nil
+	}
+
+	// Export to CSV if output file is specified, one row per event numbered by chain & step
+	if opts.OutputFile != "" {
+		columns := parser.GetPurviewEventColumns(false)
+		headers := append([]string{"Chain", "Step"}, columns...)
+
+		exporter, err := newCSVExporter(opts.OutputFile, headers, nil)
+		if err != nil {
+			return fmt.Errorf("error exporting to CSV: %v", err)
+		}
+		for number, chain := range chains {
+			for step, event := range chain.Events {
+				row := []string{fmt.Sprint(number + 1), fmt.Sprint(step + 1)}
+				for _, column := range columns {
+					row = append(row, resolveCSVValue(column, event))
+				}
+				if err := exporter.writeRow(row); err != nil {
+					exporter.close()
+					return fmt.Errorf("error exporting to CSV: %v", err)
+				}
+			}
+		}
+		if err := exporter.close(); err != nil {
+			return fmt.Errorf("error exporting to CSV: %v", err)
+		}
+		fmt.Printf("Successfully exported %d chains to %s\n", len(chains), opts.OutputFile)
+		return nil
+	}
+
+	printer := newPrinter(opts.OutputFormat)
+	for number, chain := range chains {
+		// The log format heads each chain with what ties it together, then its events in order
+		if opts.OutputFormat == "log" {
+			var joined []string
+			for index, field := range join {
+				joined = append(joined, field+"="+chain.Join[index])
+			}
+			fmt.Printf("=== Chain %d: %s, %d events over %v ===\n", number+1, strings.Join(joined, ", "), len(chain.Events), chain.End.Sub(chain.Start))
+			for step, event := range chain.Events {
+				formatted, err := format.FormatEvent(event, opts.OutputFormat)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Step %d\n%s\n", step+1, formatted)
+			}
+			continue
+		}
+
+		joinValues := make(map[string]string, len(join))
+		for index, field := range join {
+			joinValues[field] = chain.Join[index]
+		}
+
+		formatted, err := format.FormatRow(
+			[]string{"Chain", "Join", "Start", "End", "Events"},
+			[]any{number + 1, joinValues, chain.Start.Format(time.RFC3339), chain.End.Format(time.RFC3339), chain.Events},
+			opts.OutputFormat,
+		)
+		if err != nil {
+			return err
+		}
+		printer.print(formatted)
+	}
+	printer.close()
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package output
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"iter"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	// Internal dependencies
+	"CloudCutter/internal/format"
+	"CloudCutter/models"
+	"CloudCutter/tools/search"
+	"CloudCutter/tools/stats"
+)
+
+// SortKey is a field to sort results by & its direction
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// ParseSortKeys reads --sort values written as Field, Field:asc or Field:desc (a space works in place of the colon)
+func ParseSortKeys(specs []string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, spec := range specs {
+		field, direction, _ := strings.Cut(strings.TrimSpace(strings.ReplaceAll(spec, " ", ":")), ":")
+		if field == "" {
+			return nil, fmt.Errorf("invalid sort field '%s'", spec)
+		}
+
+		switch strings.ToLower(direction) {
+		case "", "asc":
+			keys = append(keys, SortKey{Field: field})
+		case "desc":
+			keys = append(keys, SortKey{Field: field, Descending: true})
+		default:
+			return nil, fmt.Errorf("invalid sort direction '%s' for %s (expected asc or desc)", direction, field)
+		}
+	}
+
+	return keys, nil
+}
+
+// Sorts the events by each key in turn, keeping the original order of ties
+// Sorting needs every result, so they are read into memory first
+func sortEvents(events iter.Seq[models.PurviewEvent], keys []SortKey) iter.Seq[models.PurviewEvent] {
+	return func(yield func(models.PurviewEvent) bool) {
+		sorted := slices.Collect(events)
+		slices.SortStableFunc(sorted, func(left, right models.PurviewEvent) int {
+			for _, key := range keys {
+				result := search.Compare(sortValue(left, key.Field), sortValue(right, key.Field))
+				if key.Descending {
+					result = -result
+				}
+				if result != 0 {
+					return result
+				}
+			}
+			return 0
+		})
+
+		for _, event := range sorted {
+			if !yield(event) {
+				return
+			}
+		}
+	}
+}
+
+// Returns the value an event is sorted by, the first of them for a path into an array
+func sortValue(event models.PurviewEvent, field string) any {
+	value := search.Resolve(event, field)
+	if values, ok := value.([]any); ok && len(values) > 0 {
+		return values[0]
+	}
+
+	return value
+}
+
+// Resolves the --fields of an event in order
+func projectEvent(event models.PurviewEvent, fields []string) []any {
+	values := make([]any, len(fields))
+	for index, field := range fields {
+		values[index] = search.Resolve(event, field)
+	}
+
+	return values
+}
+
+// distinctRow is a unique combination of --distinct values & how often it was seen
+type distinctRow struct {
+	values []string
+	count  int
+}
+
+// Collects the unique combinations of the distinct fields, most common first
+// A path into an array counts each of its values separately
+func distinctValues(events iter.Seq[models.PurviewEvent], fields []string) []*distinctRow {
+	var rows []*distinctRow
+	seen := make(map[string]*distinctRow)
+
+	for event := range events {
+		combinations := stats.Combinations(event, fields)
+		for _, combination := range combinations {
+			key := strings.Join(combination, "\x00")
+			if row, ok := seen[key]; ok {
+				row.count++
+				continue
+			}
+			row := &distinctRow{values: combination, count: 1}
+			seen[key] = row
+			rows = append(rows, row)
+		}
+	}
+
+	slices.SortStableFunc(rows, func(left, right *distinctRow) int {
+		return right.count - left.count
+	})
+
+	return rows
+}
+
+// Prints or exports the unique values of the --distinct fields with their counts
+func processDistinct(events iter.Seq[models.PurviewEvent], opts ResultOptions) error {
+	rows := distinctValues(events, opts.Distinct)
+	if opts.Limit > 0 && len(rows) > opts.Limit {
+		rows = rows[:opts.Limit]
+	}
+
+	if err := readError(opts.Err); err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		return noMatches(opts.OutputFormat, opts.OutputFile, opts.CountOnly)
+	}
+
+	if opts.CountOnly {
+		fmt.Println(len(rows))
+		return nil
+	}
+
+	names := append(slices.Clone(opts.Distinct), "Count")
+
+	// Export to CSV if output file is specified
+	if opts.OutputFile != "" {
+		exporter, err := newCSVExporter(opts.OutputFile, names, nil)
+		if err != nil {
+			return fmt.Errorf("error exporting to CSV: %v", err)
+		}
+		for _, row := range rows {
+			if err := exporter.writeRow(append(slices.Clone(row.values), fmt.Sprint(row.count))); err != nil {
+				exporter.close()
+				return fmt.Errorf("error exporting to CSV: %v", err)
+			}
+		}
+		if err := exporter.close(); err != nil {
+			return fmt.Errorf("error exporting to CSV: %v", err)
+		}
+		fmt.Printf("Successfully exported %d distinct values to %s\n", len(rows), opts.OutputFile)
+		return nil
+	}
+
+	// Tables read better than one block per value in the terminal
+	if opts.OutputFormat == "log" {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.ToUpper(strings.Join(names, "\t")))
+		for _, row := range rows {
+			fmt.Fprintf(writer, "%s\t%d\n", strings.Join(row.values, "\t"), row.count)
+		}
+		return writer.Flush()
+	}
+
+	printer := newPrinter(opts.OutputFormat)
+	for _, row := range rows {
+		values := make([]any, 0, len(names))
+		for _, value := range row.values {
+			values = append(values, value)
+		}
+		values = append(values, row.count)
+
+		formatted, err := format.FormatRow(names, values, opts.OutputFormat)
+		if err != nil {
+			return err
+		}
+		printer.print(formatted)
+	}
+	printer.close()
+
+	return nil
+}
+
+// Reports the error that stopped the input being read, so a failed read doesn't look like an empty result
+func readError(err func() error) error {
+	if err == nil {
+		return nil
+	}
+
+	return err()
+}
+
+// printer writes formatted results to the terminal
+// JSON output is a single array, so it has to be opened before the first result & closed after the last
+type printer struct {
+	jsonArray bool
+	count     int
+}
+
+func newPrinter(outputFormat string) *printer {
+	return &printer{jsonArray: outputFormat == "json"}
+}
+
+// print writes a single formatted result
+func (printer *printer) print(formatted string) {
+	if printer.jsonArray {
+		if printer.count == 0 {
+			fmt.Println("[")
+		} else {
+			fmt.Println(",")
+		}
+		fmt.Print("  " + formatted)
+	} else {
+		fmt.Println(formatted)
+	}
+	printer.count++
+}
+
+// close ends the JSON array, which is empty if nothing was printed
+func (printer *printer) close() {
+	if printer.jsonArray {
+		if printer.count == 0 {
+			fmt.Print("[")
+		}
+		fmt.Println("\n]")
+	}
+}
+
+// Reports that nothing matched, keeping machine readable output clean
+// Only the log & table formats are meant to be read by people
+func noMatches(outputFormat string, outputFile string, countOnly bool) error {
+	if !countOnly && outputFile == "" && outputFormat != "log" && outputFormat != "table" {
+		fmt.Fprintln(os.Stderr, "No matches found...")
+		return nil
+	}
+	fmt.Println("No matches found...")
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package output
+
+import (
+	// Standard library dependencies
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/format"
+	"CloudCutter/tools/stats"
+)
+
+// Output formats supported by PrintStats
+var StatsFormats = []string{"table", "csv", "json", "jsonl"}
+
+// StatsOptions holds configuration for printing grouped statistics
+type StatsOptions struct {
+	By            []string
+	Bucket        bool   // Whether the groups are split into time buckets
+	CountDistinct string // Field whose distinct values were counted
+	Limit         int
+	OutputFormat  string
+	OutputFile    string
+	Err           func() error // Reports whether reading the input failed part way through
+}
+
+// ValidateStatsFormat checks that a stats format is supported before any events are read
+func ValidateStatsFormat(outputFormat string) error {
+	if !slices.Contains(StatsFormats, outputFormat) {
+		return fmt.Errorf("unknown output format '%s' (expected one of: %s)", outputFormat, strings.Join(StatsFormats, ", "))
+	}
+
+	return nil
+}
+
+// PrintStats prints or exports one row per group with its count, distinct count, first & last seen times
+func PrintStats(groups []*stats.Group, opts StatsOptions) error {
+	if opts.Limit > 0 && len(groups) > opts.Limit {
+		groups = groups[:opts.Limit]
+	}
+
+	if err := readError(opts.Err); err != nil {
+		return err
+	}
+
+	if len(groups) == 0 {
+		return noMatches(opts.OutputFormat, opts.OutputFile, false)
+	}
+
+	names := statsColumns(opts)
+
+	// Export to CSV if output file is specified
+	if opts.OutputFile != "" {
+		exporter, err := newCSVExporter(opts.OutputFile, names, nil)
+		if err != nil {
+			return fmt.Errorf("error exporting to CSV: %v", err)
+		}
+		for _, group := range groups {
+			if err := exporter.writeRow(statsRow(group, opts)); err != nil {
+				exporter.close()
+				return fmt.Errorf("error exporting to CSV: %v", err)
+			}
+		}
+		if err := exporter.close(); err != nil {
+			return fmt.Errorf("error exporting to CSV: %v", err)
+		}
+		fmt.Printf("Successfully exported %d groups to %s\n", len(groups), opts.OutputFile)
+		return nil
+	}
+
+	switch opts.OutputFormat {
+	case "table":
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.ToUpper(strings.Join(names, "\t")))
+		for _, group := range groups {
+			fmt.Fprintln(writer, strings.Join(statsRow(group, opts), "\t"))
+		}
+		return writer.Flush()
+
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write(names)
+		for _, group := range groups {
+			writer.Write(statsRow(group, opts))
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+
+	// JSON keeps counts as numbers & leaves out times that weren't seen
+	printer := newPrinter(opts.OutputFormat)
+	for _, group := range groups {
+		values := make([]any, 0, len(names))
+		for _, value := range group.Values {
+			values = append(values, value)
+		}
+		if opts.Bucket {
+			values = append(values, formatTime(group.Bucket))
+		}
+		values = append(values, group.Count)
+		if opts.CountDistinct != "" {
+			values = append(values, group.Distinct)
+		}
+		values = append(values, formatTime(group.FirstSeen), formatTime(group.LastSeen))
+
+		formatted, err := format.FormatRow(names, values, opts.OutputFormat)
+		if err != nil {
+			return err
+		}
+		printer.print(formatted)
+	}
+	printer.close()
+
+	return nil
+}
+
+// Column names in the order statsRow writes them
+func statsColumns(opts StatsOptions) []string {
+	names := slices.Clone(opts.By)
+	if opts.Bucket {
+		names = append(names, "Bucket")
+	}
+	names = append(names, "Count")
+	if opts.CountDistinct != "" {
+		names = append(names, "Distinct"+opts.CountDistinct)
+	}
+
+	return append(names, "FirstSeen", "LastSeen")
+}
+
+// A group's values formatted as text for a table or CSV row
+func statsRow(group *stats.Group, opts StatsOptions) []string {
+	row := slices.Clone(group.Values)
+	if opts.Bucket {
+		row = append(row, formatTime(group.Bucket))
+	}
+	row = append(row, fmt.Sprint(group.Count))
+	if opts.CountDistinct != "" {
+		row = append(row, fmt.Sprint(group.Distinct))
+	}
+
+	return append(row, formatTime(group.FirstSeen), formatTime(group.LastSeen))
+}
+
+// Formats a time in the zone it was read in, leaving it blank if the events had no timestamp
+func formatTime(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+
+	return timestamp.Format(time.RFC3339)
+}
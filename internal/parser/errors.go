@@ -0,0 +1,91 @@
+package parser
+
+import (
+	// Standard library dependencies
+	"fmt"
+
+	// Internal dependencies
+	"CloudCutter/internal/logger"
+)
+
+// ParseError describes a row that was skipped or only partially parsed
+type ParseError struct {
+	Source   string // File (or zip entry) the row came from
+	Line     int    // CSV line or JSON record number, 0 when unknown
+	RecordID string // RecordID of the row if it could be read
+	Reason   string // What went wrong
+	Skipped  bool   // True if the row was dropped rather than partially parsed
+}
+
+func (parseError ParseError) Error() string {
+	location := parseError.Source
+	if parseError.Line > 0 {
+		location = fmt.Sprintf("%s:%d", parseError.Source, parseError.Line)
+	}
+	if parseError.RecordID != "" {
+		return fmt.Sprintf("%s (RecordID %s): %s", location, parseError.RecordID, parseError.Reason)
+	}
+
+	return fmt.Sprintf("%s: %s", location, parseError.Reason)
+}
+
+// Options control how malformed input is handled
+type Options struct {
+	Strict bool             // Abort on the first malformed row instead of skipping it
+	Report func(ParseError) // Called for each skipped or partially parsed row in lenient mode
+	Warn   func(string)     // Called for problems with an export as a whole, such as it not being in time order
+}
+
+// reader tracks the state shared by every export read in a stream
+type reader struct {
+	options Options
+	err     error
+}
+
+// fail records an error that stops reading altogether
+// Only the first error is kept
+func (reader *reader) fail(err error) {
+	if reader.err == nil {
+		reader.err = err
+	}
+}
+
+// problem records a malformed row & reports whether reading should carry on
+// In strict mode the first problem becomes the stream's error
+func (reader *reader) problem(parseError ParseError) bool {
+	if reader.options.Strict {
+		reader.fail(parseError)
+		return false
+	}
+
+	logger.Debugf("Parse problem: %v", parseError)
+	if reader.options.Report != nil {
+		reader.options.Report(parseError)
+	}
+
+	return true
+}
+
+// problems reports the reasons a row was only partially parsed & whether reading should carry on
+func (reader *reader) problems(source string, line int, recordID string, reasons []string) bool {
+	for _, reason := range reasons {
+		if !reader.problem(ParseError{Source: source, Line: line, RecordID: recordID, Reason: reason}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// warn reports a problem with an export that doesn't stop it being read
+func (reader *reader) warn(message string) {
+	logger.Debugf("Warning: %s", message)
+	if reader.options.Warn != nil {
+		reader.options.Warn(message)
+	}
+}
+
+// stopped reports whether a fatal error has been recorded
+func (reader *reader) stopped() bool {
+	return reader.err != nil
+}
@@ -0,0 +1,441 @@
+package parser
+
+import (
+	// Standard library dependencies
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/logger"
+	"CloudCutter/models"
+)
+
+// Builds a normalised event from top-level columns & optionally an already parsed AuditData object
+// Column names are expected in lower case. When auditMap is nil the auditdata column is parsed instead
+// Any values that couldn't be parsed are returned as problems alongside the partial event
+func newEvent(source string, columns map[string]string, auditMap map[string]interface{}) (models.PurviewEvent, []string) {
+	var problems []string
+
+	event := models.PurviewEvent{
+		SourceFile: source,
+		LogSource:  models.LogSourcePurview,
+		RawData:    make(map[string]interface{}),
+		AuditData:  make(map[string]interface{}),
+		Flattened:  make(map[string]interface{}),
+	}
+
+	// For each column, add the value to RawData and Flattened maps
+	for columnName, value := range columns {
+		event.RawData[columnName] = value
+		event.Flattened[columnName] = value
+
+		// Extract known top-level fields
+		switch columnName {
+		case "recordid":
+			event.RecordID = value
+		case "creationdate":
+			if err := setTimestamp(&event, value); err != nil {
+				problems = append(problems, err.Error())
+			}
+		case "operation":
+			event.Operation = value
+		case "operationproperties":
+			event.OperationProperties = value
+		case "userid":
+			event.UserID = value
+		case "organizationname":
+			event.Organisation = value
+		case "eventsource":
+			event.EventSource = value
+		case "workload":
+			event.M365Service = value
+		case "clientip":
+			event.ClientIP = value
+		case "clientappname":
+			event.ClientAppName = value
+		case "client":
+			event.Client = value
+		case "useragent":
+			event.UserAgent = value
+		case "actorinfo":
+			event.ActorInfo = value
+		case "affecteditems":
+			event.AffectedItems = value
+		case "folders":
+			event.Folders = value
+		case "folder":
+			event.Folder = value
+		case "destinationfolder":
+			event.DestinationFolder = value
+		}
+	}
+
+	// Parse AuditData JSON column if present
+	// If the string is not empty attempt to parse it
+	if auditDataStr := columns["auditdata"]; auditMap == nil && auditDataStr != "" && auditDataStr != "{}" {
+		// Error handling for JSON parsing
+		if err := json.Unmarshal([]byte(auditDataStr), &auditMap); err == nil {
+			logger.Debugf("Parsed AuditData JSON for RecordID: %s", event.RecordID)
+		} else {
+			problems = append(problems, fmt.Sprintf("invalid AuditData JSON: %v", err))
+		}
+	}
+
+	if auditMap != nil {
+		problems = append(problems, applyAuditData(&event, auditMap)...)
+	}
+
+	return event, problems
+}
+
+// Stores the parsed audit data on the event, flattens it & promotes well-known fields
+// Returns any values that couldn't be parsed
+func applyAuditData(event *models.PurviewEvent, auditMap map[string]interface{}) []string {
+	var problems []string
+
+	// Store the parsed audit data in the event struct
+	event.AuditData = auditMap
+
+	// Flatten nested JSON fields into the main map
+	for key, value := range auditMap {
+		keyLower := strings.ToLower(key)
+		event.Flattened[keyLower] = value
+		flatten(keyLower, value, event.Flattened)
+		keyProperties(key, value, event)
+
+		// Promote RecordID
+		if event.RecordID == "" && keyLower == "id" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.RecordID = stringValue
+			}
+		}
+
+		// Promote Timestamp
+		if event.Timestamp == "" && keyLower == "creationtime" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				if err := setTimestamp(event, stringValue); err != nil {
+					problems = append(problems, err.Error())
+				}
+			}
+		}
+
+		// Promote Operation
+		if event.Operation == "" && keyLower == "operation" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.Operation = stringValue
+			}
+		}
+
+		// Promote ClientIP
+		if event.ClientIP == "" && (keyLower == "clientip" || keyLower == "clientipaddress") {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.ClientIP = stringValue
+			}
+		}
+
+		// Promote UserID
+		if event.UserID == "" && (keyLower == "userid" || keyLower == "userkey") {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.UserID = stringValue
+			}
+		}
+
+		// Promote Organisation
+		if event.Organisation == "" && keyLower == "organizationname" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.Organisation = stringValue
+			}
+		}
+
+		// Promote OperationProperties
+		if event.OperationProperties == "" && keyLower == "operationproperties" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.OperationProperties = stringValue
+			}
+		}
+
+		// Promote ClientAppName
+		if event.ClientAppName == "" && keyLower == "clientappname" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.ClientAppName = stringValue
+			}
+		}
+
+		// Promote M365Service
+		if event.M365Service == "" && keyLower == "workload" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.M365Service = stringValue
+			}
+		}
+
+		// Promote UserAgent
+		if event.UserAgent == "" && keyLower == "useragent" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.UserAgent = stringValue
+			}
+		}
+
+		// Promote ActorInfo
+		if event.ActorInfo == "" && keyLower == "actorinfostring" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.ActorInfo = stringValue
+			}
+		}
+
+		// Promote Client
+		if event.Client == "" && keyLower == "client" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.Client = stringValue
+			}
+		}
+
+		// Promote EventSource
+		if event.EventSource == "" && keyLower == "eventsource" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.EventSource = stringValue
+			}
+		}
+
+		// Promote AffectedItems
+		if event.AffectedItems == "" && keyLower == "affecteditems" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.AffectedItems = stringValue
+			} else {
+				// Marshal complex types (arrays/objects) back to string
+				if jsonBytes, err := json.Marshal(value); err == nil {
+					event.AffectedItems = string(jsonBytes)
+				}
+			}
+		}
+
+		// Promote Folders
+		if event.Folders == "" && keyLower == "folders" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.Folders = stringValue
+			} else {
+				// Marshal complex types (arrays/objects) back to string
+				if jsonBytes, err := json.Marshal(value); err == nil {
+					event.Folders = string(jsonBytes)
+				}
+			}
+		}
+
+		// Promote Folder
+		if event.Folder == "" && keyLower == "folder" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.Folder = stringValue
+			} else {
+				// Marshal complex types (arrays/objects) back to string
+				if jsonBytes, err := json.Marshal(value); err == nil {
+					event.Folder = string(jsonBytes)
+				}
+			}
+		}
+
+		// Promote DestinationFolder
+		if event.DestinationFolder == "" && keyLower == "destinationfolder" {
+			if stringValue, typeMatch := value.(string); typeMatch {
+				event.DestinationFolder = stringValue
+			} else {
+				// Marshal complex types (arrays/objects) back to string
+				if jsonBytes, err := json.Marshal(value); err == nil {
+					event.DestinationFolder = string(jsonBytes)
+				}
+			}
+		}
+
+		// Look for 'Folders' array which often contains 'FolderItems' (Emails)
+		if keyLower == "folders" {
+			if folders, ok := value.([]interface{}); ok {
+				for _, f := range folders {
+					if folderMap, ok := f.(map[string]interface{}); ok {
+						if items, ok := folderMap["FolderItems"].([]interface{}); ok {
+							for _, item := range items {
+								if itemMap, ok := item.(map[string]interface{}); ok {
+									email := models.EmailItem{
+										ID:                fmt.Sprint(itemMap["Id"]),
+										Subject:           fmt.Sprint(itemMap["Subject"]),
+										InternetMessageID: fmt.Sprint(itemMap["InternetMessageId"]),
+									}
+									// Handle numeric size conversion
+									if size, ok := itemMap["SizeInBytes"].(float64); ok {
+										email.SizeInBytes = int64(size)
+									}
+									event.Emails = append(event.Emails, email)
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+
+		// Check if this record is a File/SharePoint operation
+		if keyLower == "sourcefilename" && value != nil {
+			file := models.FileItem{
+				FileName: fmt.Sprint(value),
+			}
+			if ext, ok := auditMap["SourceFileExtension"].(string); ok {
+				file.FileExtension = ext
+			}
+			if site, ok := auditMap["SiteUrl"].(string); ok {
+				file.SiteURL = site
+			}
+			if obj, ok := auditMap["ObjectId"].(string); ok {
+				file.ObjectID = obj
+			}
+			event.Files = append(event.Files, file)
+		}
+	}
+
+	return problems
+}
+
+// Adds every value nested below prefix to the map under its dotted path, in lower case
+// Objects add a segment per key & arrays a segment per index, so the path of the first
+// folder in AuditData is folders.0.path. Objects & arrays are also kept at their own path
+func flatten(prefix string, value interface{}, into map[string]interface{}) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			path := prefix + "." + strings.ToLower(key)
+			into[path] = child
+			flatten(path, child, into)
+		}
+	case []interface{}:
+		for index, child := range typed {
+			path := prefix + "." + strconv.Itoa(index)
+			into[path] = child
+			flatten(path, child, into)
+		}
+	}
+}
+
+// Converts [{Name, Value}] arrays nested below path into keyed properties on the event
+// Parameters: [{Name: ForwardTo, Value: x}] becomes Parameters.ForwardTo = x. ModifiedProperties
+// entries hold NewValue & OldValue instead, which become X (the new value), X.NewValue and X.OldValue
+// Properties keep the original case for display & are added to Flattened in lower case
+func keyProperties(path string, value interface{}, event *models.PurviewEvent) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			keyProperties(path+"."+key, child, event)
+		}
+	case []interface{}:
+		if !isNameValueArray(typed) {
+			for index, child := range typed {
+				keyProperties(path+"."+strconv.Itoa(index), child, event)
+			}
+			return
+		}
+
+		if event.Properties == nil {
+			event.Properties = make(map[string]any)
+		}
+		setProperty := func(key string, value interface{}) {
+			event.Properties[key] = value
+			event.Flattened[strings.ToLower(key)] = value
+		}
+
+		for _, child := range typed {
+			entry := child.(map[string]interface{})
+			name := path + "." + fmt.Sprint(entry["Name"])
+
+			if value, ok := entry["Value"]; ok {
+				setProperty(name, value)
+				continue
+			}
+			if newValue, ok := entry["NewValue"]; ok {
+				setProperty(name, newValue)
+				setProperty(name+".NewValue", newValue)
+			}
+			if oldValue, ok := entry["OldValue"]; ok {
+				setProperty(name+".OldValue", oldValue)
+			}
+		}
+	}
+}
+
+// Checks whether every element of an array is a {Name, Value} or {Name, NewValue, OldValue} object
+func isNameValueArray(array []interface{}) bool {
+	if len(array) == 0 {
+		return false
+	}
+
+	for _, element := range array {
+		entry, ok := element.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, hasName := entry["Name"]; !hasName {
+			return false
+		}
+
+		_, hasValue := entry["Value"]
+		_, hasNewValue := entry["NewValue"]
+		_, hasOldValue := entry["OldValue"]
+		if !hasValue && !hasNewValue && !hasOldValue {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Parses a creation date & sets the Timestamp, Date and Time fields in UTC
+func setTimestamp(event *models.PurviewEvent, value string) error {
+	// Remove leading/trailing spaces
+	cleanValue := strings.TrimSpace(value)
+
+	timeValue, err := parseTimestamp(cleanValue)
+	if err != nil {
+		return fmt.Errorf("failed to parse time '%s'", cleanValue)
+	}
+
+	event.Timestamp = timeValue.UTC().Format(time.RFC3339)
+	event.TimestampUTC = event.Timestamp
+	event.Date = timeValue.UTC().Format("2006-01-02")
+	event.Time = timeValue.UTC().Format("15:04:05")
+
+	return nil
+}
+
+// Parses the timestamp formats found in Purview & Unified Audit Log exports
+func parseTimestamp(value string) (time.Time, error) {
+	// Windows PowerShell's ConvertTo-Json writes dates as /Date(milliseconds)/
+	if strings.HasPrefix(value, "/Date(") && strings.HasSuffix(value, ")/") {
+		milliseconds := strings.TrimSuffix(strings.TrimPrefix(value, "/Date("), ")/")
+		// Drop any timezone offset, the milliseconds are always since the Unix epoch in UTC
+		// An empty /Date()/ is left to fail below like any other unreadable time
+		if milliseconds != "" {
+			if index := strings.IndexAny(milliseconds[1:], "+-"); index >= 0 {
+				milliseconds = milliseconds[:index+1]
+			}
+		}
+		if ms, err := strconv.ParseInt(milliseconds, 10, 64); err == nil {
+			return time.UnixMilli(ms).UTC(), nil
+		}
+	}
+
+	// Try RFC3339 (This handles the .0000000Z format perfectly)
+	timeValue, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		// Fallback: Only normalise if RFC3339 failed
+		normalized := strings.Replace(value, " ", "T", 1)
+		timeValue, err = time.Parse("2006-01-02T15:04:05", normalized)
+	}
+
+	// Fallback: US-style dates written by the Entra ID portal
+	for _, layout := range []string{"1/2/2006, 3:04:05 PM", "1/2/2006 3:04:05 PM"} {
+		if err == nil {
+			break
+		}
+		timeValue, err = time.Parse(layout, value)
+	}
+
+	return timeValue, err
+}
@@ -0,0 +1,234 @@
+package parser
+
+import (
+	// Standard library dependencies
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	// Internal dependencies
+	"CloudCutter/internal/logger"
+	"CloudCutter/models"
+)
+
+// Magic numbers of the compressed formats exports arrive in
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+)
+
+// Byte order marks written by Excel & PowerShell
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// fileStreams returns one event stream per export held in a file
+// A zip archive yields a stream for every supported file inside it, anything else a single stream
+func (reader *reader) fileStreams(filePath string) ([]iter.Seq[models.PurviewEvent], error) {
+	if !hasMagic(filePath, zipMagic) {
+		return []iter.Seq[models.PurviewEvent]{reader.streamFile(filePath)}, nil
+	}
+
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip archive %s: %v", filePath, err)
+	}
+	defer archive.Close()
+
+	var streams []iter.Seq[models.PurviewEvent]
+	for _, entry := range archive.File {
+		extension := strings.ToLower(filepath.Ext(entry.Name))
+		if entry.FileInfo().IsDir() || extension == ".zip" || !slices.Contains(supportedExtensions, extension) {
+			continue
+		}
+
+		streams = append(streams, reader.streamZipEntry(filePath, entry.Name))
+	}
+	logger.Debugf("Found %d exports in zip archive %s", len(streams), filePath)
+
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("no exports found in zip archive %s", filePath)
+	}
+
+	return streams, nil
+}
+
+// Streams events from an export one record at a time
+// Gzip compression & UTF-16 or UTF-8 BOM text are handled transparently
+// The file is only read as the sequence is consumed, so stopping early stops reading
+func (reader *reader) streamFile(filePath string) iter.Seq[models.PurviewEvent] {
+	return func(yield func(models.PurviewEvent) bool) {
+		// Open the file
+		file, err := os.Open(filePath)
+
+		// Error handling for file opening
+		if err != nil {
+			reader.fail(fmt.Errorf("failed to open input file: %v", err))
+			return
+		}
+		logger.Debugf("Opened input file: %s", filePath)
+		defer file.Close()
+
+		content, err := decompress(file)
+		if err != nil {
+			reader.fail(fmt.Errorf("failed to decompress %s: %v", filePath, err))
+			return
+		}
+
+		reader.streamContent(filePath, decodeText(content), yield)
+	}
+}
+
+// Streams events from a single file inside a zip archive
+// The archive is re-opened when the stream is consumed so no file handles are held in the meantime
+func (reader *reader) streamZipEntry(archivePath string, entryName string) iter.Seq[models.PurviewEvent] {
+	source := archivePath + ":" + entryName
+
+	return func(yield func(models.PurviewEvent) bool) {
+		archive, err := zip.OpenReader(archivePath)
+		if err != nil {
+			reader.fail(fmt.Errorf("failed to open zip archive %s: %v", archivePath, err))
+			return
+		}
+		defer archive.Close()
+
+		entry, err := archive.Open(entryName)
+		if err != nil {
+			reader.fail(fmt.Errorf("failed to open %s: %v", source, err))
+			return
+		}
+		defer entry.Close()
+		logger.Debugf("Opened zip entry: %s", source)
+
+		content, err := decompress(entry)
+		if err != nil {
+			reader.fail(fmt.Errorf("failed to decompress %s: %v", source, err))
+			return
+		}
+
+		reader.streamContent(source, decodeText(content), yield)
+	}
+}
+
+// Checks whether a file starts with the given magic number
+func hasMagic(filePath string, magic []byte) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+
+	return bytes.Equal(header, magic)
+}
+
+// Wraps gzip compressed content in a decompressing reader
+func decompress(content io.Reader) (io.Reader, error) {
+	input := bufio.NewReader(content)
+	if header, err := input.Peek(len(gzipMagic)); err == nil && bytes.Equal(header, gzipMagic) {
+		logger.Debugf("Detected gzip compressed content")
+		return gzip.NewReader(input)
+	}
+
+	return input, nil
+}
+
+// Normalises text to UTF-8 without a byte order mark
+// UTF-16 is detected from its byte order mark, or from the zero bytes of ASCII text when there is none
+func decodeText(content io.Reader) io.Reader {
+	input := bufio.NewReader(content)
+	header, _ := input.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(header, utf8BOM):
+		input.Discard(len(utf8BOM))
+		return input
+	case bytes.HasPrefix(header, utf16LEBOM):
+		input.Discard(len(utf16LEBOM))
+		logger.Debugf("Detected UTF-16LE text")
+		return &utf16Reader{input: input, order: binary.LittleEndian}
+	case bytes.HasPrefix(header, utf16BEBOM):
+		input.Discard(len(utf16BEBOM))
+		logger.Debugf("Detected UTF-16BE text")
+		return &utf16Reader{input: input, order: binary.BigEndian}
+	case len(header) == 4 && header[0] != 0 && header[1] == 0 && header[2] != 0 && header[3] == 0:
+		logger.Debugf("Detected UTF-16LE text without a byte order mark")
+		return &utf16Reader{input: input, order: binary.LittleEndian}
+	case len(header) == 4 && header[0] == 0 && header[1] != 0 && header[2] == 0 && header[3] != 0:
+		logger.Debugf("Detected UTF-16BE text without a byte order mark")
+		return &utf16Reader{input: input, order: binary.BigEndian}
+	}
+
+	return input
+}
+
+// utf16Reader converts UTF-16 text to UTF-8 as it is read
+type utf16Reader struct {
+	input   *bufio.Reader
+	order   binary.ByteOrder
+	pending []byte // Encoded UTF-8 that didn't fit in the last read
+}
+
+func (decoder *utf16Reader) Read(buffer []byte) (int, error) {
+	written := copy(buffer, decoder.pending)
+	decoder.pending = decoder.pending[written:]
+
+	for written < len(buffer) {
+		unit, err := decoder.readUnit()
+		if err != nil {
+			if written > 0 {
+				return written, nil
+			}
+			return 0, err
+		}
+
+		// Combine surrogate pairs into a single code point
+		value := rune(unit)
+		if utf16.IsSurrogate(value) {
+			next, err := decoder.readUnit()
+			if err != nil {
+				value = unicode.ReplacementChar
+			} else {
+				value = utf16.DecodeRune(value, rune(next))
+			}
+		}
+
+		encoded := utf8.AppendRune(nil, value)
+		count := copy(buffer[written:], encoded)
+		written += count
+		decoder.pending = append(decoder.pending, encoded[count:]...)
+	}
+
+	return written, nil
+}
+
+// readUnit reads a single 16-bit code unit
+func (decoder *utf16Reader) readUnit() (uint16, error) {
+	var unit [2]byte
+	if _, err := io.ReadFull(decoder.input, unit[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+
+	return decoder.order.Uint16(unit[:]), nil
+}
@@ -0,0 +1,255 @@
+package parser
+
+import (
+	// Standard library dependencies
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	// Internal dependencies
+	"CloudCutter/internal/logger"
+	"CloudCutter/models"
+)
+
+// Search-UnifiedAuditLog names some columns differently to the Purview CSV export
+var jsonColumnAliases = map[string]string{
+	"userids":    "userid",
+	"operations": "operation",
+	"identity":   "recordid",
+}
+
+// Returns the first non-whitespace byte of the input without consuming it
+func firstByte(input *bufio.Reader) byte {
+	for offset := 1; ; offset++ {
+		peeked, err := input.Peek(offset)
+		if err != nil {
+			return 0
+		}
+
+		switch next := peeked[offset-1]; next {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return next
+		}
+	}
+}
+
+// Checks whether the input starts with a JSON array or object
+func isJSON(input *bufio.Reader) bool {
+	first := firstByte(input)
+	return first == '[' || first == '{'
+}
+
+// parsedRecord is an event alongside any values that couldn't be parsed
+type parsedRecord struct {
+	event    models.PurviewEvent
+	problems []string
+}
+
+// Streams events from Unified Audit Log JSON one record at a time
+// Accepts a JSON array (ConvertTo-Json), a single object or one object per line (JSONL)
+func (reader *reader) streamJSON(source string, input *bufio.Reader, yield func(models.PurviewEvent) bool) {
+	// JSONL is read line by line so a malformed line can be skipped
+	if isJSONLines(input) {
+		logger.Debugf("Reading %s as JSON lines", source)
+		reader.streamJSONLines(source, input, yield)
+		return
+	}
+
+	inArray := firstByte(input) == '['
+	decoder := json.NewDecoder(input)
+
+	// Step into a top-level array so records can be decoded one at a time
+	if inArray {
+		if _, err := decoder.Token(); err != nil {
+			reader.fail(fmt.Errorf("failed to read JSON from %s: %v", source, err))
+			return
+		}
+	}
+
+	count := 0
+	for number := 1; ; number++ {
+		if inArray && !decoder.More() {
+			break // End of array reached
+		}
+
+		// Read each record without interpreting it yet
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+
+		// Error handing for end of file
+		if err == io.EOF {
+			break
+		}
+
+		// Broken JSON can't be resynchronised, so nothing after it can be read
+		if err != nil {
+			reader.fail(fmt.Errorf("failed to read JSON record %d from %s: %v", number, source, err))
+			return
+		}
+
+		if !reader.yieldJSONRecord(source, number, raw, &count, yield) {
+			return
+		}
+	}
+
+	logger.Debugf("Parsed %d events from JSON", count)
+}
+
+// Streams events from JSONL one line at a time
+func (reader *reader) streamJSONLines(source string, input *bufio.Reader, yield func(models.PurviewEvent) bool) {
+	count := 0
+	for line := 1; ; line++ {
+		text, err := input.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			reader.fail(fmt.Errorf("failed to read %s: %v", source, err))
+			return
+		}
+
+		if trimmed := bytes.TrimSpace(text); len(trimmed) > 0 {
+			if !reader.yieldJSONRecord(source, line, trimmed, &count, yield) {
+				return
+			}
+		}
+
+		// Error handing for end of file
+		if err == io.EOF {
+			break
+		}
+	}
+
+	logger.Debugf("Parsed %d events from JSON lines", count)
+}
+
+// Parses a single JSON record, reports any problems & yields its events
+// Returns false once reading should stop
+func (reader *reader) yieldJSONRecord(source string, number int, raw []byte, count *int, yield func(models.PurviewEvent) bool) bool {
+	records, err := parseJSONRecord(source, raw)
+	if err != nil {
+		return reader.problem(ParseError{Source: source, Line: number, Reason: err.Error(), Skipped: true})
+	}
+
+	for _, record := range records {
+		if !reader.problems(source, number, record.event.RecordID, record.problems) {
+			return false
+		}
+		*count++
+
+		// Stop reading if the consumer is done
+		if !yield(record.event) {
+			logger.Debugf("Stopped reading %s after %d events", source, *count)
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks whether the input holds one JSON object per line
+// The first line must be a complete object on its own, which a pretty-printed object never is
+func isJSONLines(input *bufio.Reader) bool {
+	if firstByte(input) != '{' {
+		return false
+	}
+
+	// Peek a little more each time until the whole first line is buffered
+	for size := 4096; ; size *= 2 {
+		peeked, err := input.Peek(size)
+		if index := bytes.IndexByte(peeked, '\n'); index >= 0 {
+			return json.Valid(bytes.TrimSpace(peeked[:index]))
+		}
+		if err != nil {
+			// The whole input is a single line
+			return false
+		}
+		if size >= input.Size() {
+			// Lines longer than the buffer are read as a JSON stream instead
+			return false
+		}
+	}
+}
+
+// Builds normalised events from a single JSON record
+// Search-UnifiedAuditLog records wrap the audit data in an AuditData property, whereas
+// Management Activity API records are the audit data itself. A saved Graph response holds
+// its sign-in records in a value array
+func parseJSONRecord(source string, raw json.RawMessage) ([]parsedRecord, error) {
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &properties); err != nil {
+		return nil, fmt.Errorf("invalid JSON record: %v", err)
+	}
+
+	// Graph response page: parse each record in the value array
+	if values, ok := properties["value"]; ok && properties["@odata.context"] != nil {
+		var records []json.RawMessage
+		if err := json.Unmarshal(values, &records); err != nil {
+			return nil, fmt.Errorf("invalid Graph response: %v", err)
+		}
+
+		var parsed []parsedRecord
+		for _, record := range records {
+			recordEvents, err := parseJSONRecord(source, record)
+			if err != nil {
+				return nil, err
+			}
+			parsed = append(parsed, recordEvents...)
+		}
+		return parsed, nil
+	}
+
+	// Entra ID sign-in record
+	if isSignInRecord(properties) {
+		event, problems := parseSignInJSON(source, properties)
+		return []parsedRecord{{event, problems}}, nil
+	}
+
+	// Look for an AuditData property, whatever its case
+	wrapped := false
+	for key := range properties {
+		if strings.EqualFold(key, "auditdata") {
+			wrapped = true
+			break
+		}
+	}
+
+	// Management Activity API record: treat the whole record as the AuditData column
+	if !wrapped {
+		event, problems := newEvent(source, map[string]string{"auditdata": string(raw)}, nil)
+		return []parsedRecord{{event, problems}}, nil
+	}
+
+	// Search-UnifiedAuditLog record: convert each property to a column value
+	columns := make(map[string]string, len(properties))
+	for key, value := range properties {
+		columns[strings.ToLower(key)] = jsonColumnValue(value)
+	}
+
+	// Fill in the Purview column names from their Search-UnifiedAuditLog equivalents
+	for alias, columnName := range jsonColumnAliases {
+		if columns[columnName] == "" && columns[alias] != "" {
+			columns[columnName] = columns[alias]
+		}
+	}
+
+	event, problems := newEvent(source, columns, nil)
+	return []parsedRecord{{event, problems}}, nil
+}
+
+// Converts a JSON value to the string a CSV export would hold
+// Strings are unquoted, null is empty & anything else keeps its JSON text
+func jsonColumnValue(value json.RawMessage) string {
+	var stringValue string
+	if err := json.Unmarshal(value, &stringValue); err == nil {
+		return stringValue
+	}
+
+	if string(value) == "null" {
+		return ""
+	}
+
+	return string(value)
+}
@@ -2,13 +2,12 @@ package parser
 
 import (
 	// Standard library dependencies
+	"bufio"
 	"encoding/csv"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strings"
-	"time"
 
 	// Internal dependencies
 	"CloudCutter/internal/logger"
@@ -16,12 +15,13 @@ import (
 )
 
 // Get Purview event columns
-func GetPurviewEventColumns(events []models.PurviewEvent, includeSigma bool) []string {
+func GetPurviewEventColumns(includeSigma bool) []string {
 	cols := []string{
 		"RecordID",
 		"Date",
 		"Time",
 		"Timestamp",
+		"TimestampUTC",
 	}
 
 	if includeSigma {
@@ -49,6 +49,13 @@ func GetPurviewEventColumns(events []models.PurviewEvent, includeSigma bool) []s
 		"Folders",
 		"Folder",
 		"DestinationFolder",
+		"ResultStatus",
+		"ConditionalAccess",
+		"MFAResult",
+		"Location",
+		"CorrelationID",
+		"SessionID",
+		"LogSource",
 		"SourceFile",
 		"Emails",
 		"Emails.ID",
@@ -67,30 +74,36 @@ func GetPurviewEventColumns(events []models.PurviewEvent, includeSigma bool) []s
 	return cols
 }
 
-// Reads Purview CSV and returns a slice of PurviewEvent structs
-func ParsePurviewCSV(filePath string) []models.PurviewEvent {
-	// Open the CSV file
-	file, err := os.Open(filePath)
-
-	// Error handling for file opening
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to open CSV file: %v\n", err)
-		return nil
+// Size of the read buffer, large enough to hold the first line of a JSONL export
+const contentBufferSize = 1 << 20
+
+// Streams events from decoded export content one record at a time
+// The format (Purview CSV, UAL JSON or JSONL, Entra ID sign-in CSV or JSON) is detected from the contents
+func (reader *reader) streamContent(source string, content io.Reader, yield func(models.PurviewEvent) bool) {
+	input := bufio.NewReaderSize(content, contentBufferSize)
+	if isJSON(input) {
+		logger.Debugf("Detected JSON content in %s", source)
+		reader.streamJSON(source, input, yield)
+		return
 	}
-	logger.Debugf("Opened CSV file: %s", filePath)
-	defer file.Close()
 
+	reader.streamCSV(source, input, yield)
+}
+
+// Streams events from a Purview CSV one row at a time
+func (reader *reader) streamCSV(source string, input io.Reader, yield func(models.PurviewEvent) bool) {
 	// Create a new CSV reader
-	reader := csv.NewReader(file)
-	reader.FieldsPerRecord = -1 // Allow variable number of fields
+	csvReader := csv.NewReader(input)
+	csvReader.FieldsPerRecord = -1 // Allow variable number of fields
+	csvReader.ReuseRecord = true   // Values are copied into the event maps, so the slice can be reused
 
 	// Read the header row
-	headers, err := reader.Read()
+	headers, err := csvReader.Read()
 
 	// Error handling for reading headers
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to read CSV headers: %v\n", err)
-		return nil
+		reader.fail(fmt.Errorf("failed to read CSV headers from %s: %v", source, err))
+		return
 	}
 
 	// Map header names to their column indices for easy access
@@ -100,280 +113,62 @@ func ParsePurviewCSV(filePath string) []models.PurviewEvent {
 	}
 	logger.Debugf("Mapped %d CSV headers", len(headerMap))
 
-	var events []models.PurviewEvent
+	// Entra ID sign-in exports have their own columns
+	parse := parseRecord
+	if isSignInHeader(headerMap) {
+		logger.Debugf("Detected Entra ID sign-in log columns in %s", source)
+		parse = parseSignInRecord
+	}
 
+	count := 0
 	for {
 		// Read each record from the CSV
-		record, err := reader.Read()
+		record, err := csvReader.Read()
 
 		// Error handing for end of file
 		if err == io.EOF {
 			break // End of file reached
 		}
 
-		event := models.PurviewEvent{
-			SourceFile: filePath,
-			RawData:    make(map[string]interface{}),
-			AuditData:  make(map[string]interface{}),
-			Flattened:  make(map[string]interface{}),
-		}
-
-		// For each column in the header, add the value to RawData and Flattened maps
-		for columnName, index := range headerMap {
-			// Ensure loop doesn't go out of bounds if the record has fewer columns than the header
-			if index < len(record) {
-				value := record[index]
-				event.RawData[columnName] = value
-				event.Flattened[columnName] = value
-
-				// Extract known top-level fields
-				switch columnName {
-				case "recordid":
-					event.RecordID = value
-				case "creationdate":
-					// Remove leading/trailing spaces
-					cleanValue := strings.TrimSpace(value)
-
-					// Try RFC3339 (This handles the .0000000Z format perfectly)
-					timeValue, err := time.Parse(time.RFC3339, cleanValue)
-
-					if err != nil {
-						// Fallback: Only normalise if RFC3339 failed
-						normalized := strings.Replace(cleanValue, " ", "T", 1)
-						timeValue, err = time.Parse("2006-01-02T15:04:05", normalized)
-					}
-
-					if err == nil {
-						event.Timestamp = timeValue.UTC().Format(time.RFC3339)
-						event.Date = timeValue.UTC().Format("2006-01-02")
-						event.Time = timeValue.UTC().Format("15:04:05")
-					} else {
-						fmt.Fprintf(os.Stderr, "failed to parse time '%s': %v\n", cleanValue, err)
-					}
-				case "operation":
-					event.Operation = value
-				case "operationproperties":
-					event.OperationProperties = value
-				case "userid":
-					event.UserID = value
-				case "organizationname":
-					event.Organisation = value
-				case "eventsource":
-					event.EventSource = value
-				case "workload":
-					event.M365Service = value
-				case "clientip":
-					event.ClientIP = value
-				case "clientappname":
-					event.ClientAppName = value
-				case "client":
-					event.Client = value
-				case "useragent":
-					event.UserAgent = value
-				case "actorinfo":
-					event.ActorInfo = value
-				case "affecteditems":
-					event.AffectedItems = value
-				case "folders":
-					event.Folders = value
-				case "folder":
-					event.Folder = value
-				case "destinationfolder":
-					event.DestinationFolder = value
-				}
+		// Malformed rows are skipped, anything else means the file can't be read any further
+		if err != nil {
+			var csvError *csv.ParseError
+			if !errors.As(err, &csvError) {
+				reader.fail(fmt.Errorf("failed to read %s: %v", source, err))
+				return
 			}
+			if !reader.problem(ParseError{Source: source, Line: csvError.StartLine, Reason: csvError.Err.Error(), Skipped: true}) {
+				return
+			}
+			continue
 		}
 
-		// Parse AuditData JSON column if present
-		if index, ok := headerMap["auditdata"]; ok && index < len(record) {
-			// Get the raw JSON string
-			auditDataStr := record[index]
-
-			// If the string is not empty attempt to parse it
-			if auditDataStr != "" && auditDataStr != "{}" {
-				// Parse the JSON into a map
-				var auditMap map[string]interface{}
-
-				// Error handling for JSON parsing
-				if err := json.Unmarshal([]byte(auditDataStr), &auditMap); err == nil {
-					// Store the parsed audit data in the event struct
-					event.AuditData = auditMap
-					logger.Debugf("Parsed AuditData JSON for RecordID: %s", event.RecordID)
-
-					// Flatten nested JSON fields into the main map
-					for key, value := range auditMap {
-						keyLower := strings.ToLower(key)
-						event.Flattened[keyLower] = value
-
-						// Promote ClientIP
-						if event.ClientIP == "" && (keyLower == "clientip" || keyLower == "clientipaddress") {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.ClientIP = stringValue
-							}
-						}
-
-						// Promote UserID
-						if event.UserID == "" && (keyLower == "userid" || keyLower == "userkey") {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.UserID = stringValue
-							}
-						}
-
-						// Promote Organisation
-						if event.Organisation == "" && keyLower == "organizationname" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.Organisation = stringValue
-							}
-						}
-
-						// Promote OperationProperties
-						if event.OperationProperties == "" && keyLower == "operationproperties" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.OperationProperties = stringValue
-							}
-						}
-
-						// Promote ClientAppName
-						if event.ClientAppName == "" && keyLower == "clientappname" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.ClientAppName = stringValue
-							}
-						}
-
-						// Promote M365Service
-						if event.M365Service == "" && keyLower == "workload" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.M365Service = stringValue
-							}
-						}
-
-						// Promote UserAgent
-						if event.UserAgent == "" && keyLower == "useragent" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.UserAgent = stringValue
-							}
-						}
-
-						// Promote ActorInfo
-						if event.ActorInfo == "" && keyLower == "actorinfostring" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.ActorInfo = stringValue
-							}
-						}
-
-						// Promote Client
-						if event.Client == "" && keyLower == "client" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.Client = stringValue
-							}
-						}
-
-						// Promote EventSource
-						if event.EventSource == "" && keyLower == "eventsource" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.EventSource = stringValue
-							}
-						}
-
-						// Promote AffectedItems
-						if event.AffectedItems == "" && keyLower == "affecteditems" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.AffectedItems = stringValue
-							} else {
-								// Marshal complex types (arrays/objects) back to string
-								if jsonBytes, err := json.Marshal(value); err == nil {
-									event.AffectedItems = string(jsonBytes)
-								}
-							}
-						}
-
-						// Promote Folders
-						if event.Folders == "" && keyLower == "folders" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.Folders = stringValue
-							} else {
-								// Marshal complex types (arrays/objects) back to string
-								if jsonBytes, err := json.Marshal(value); err == nil {
-									event.Folders = string(jsonBytes)
-								}
-							}
-						}
-
-						// Promote Folder
-						if event.Folder == "" && keyLower == "folder" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.Folder = stringValue
-							} else {
-								// Marshal complex types (arrays/objects) back to string
-								if jsonBytes, err := json.Marshal(value); err == nil {
-									event.Folder = string(jsonBytes)
-								}
-							}
-						}
+		line, _ := csvReader.FieldPos(0)
+		event, problems := parse(source, headerMap, record)
+		if !reader.problems(source, line, event.RecordID, problems) {
+			return
+		}
+		count++
 
-						// Promote DestinationFolder
-						if event.DestinationFolder == "" && keyLower == "destinationfolder" {
-							if stringValue, typeMatch := value.(string); typeMatch {
-								event.DestinationFolder = stringValue
-							} else {
-								// Marshal complex types (arrays/objects) back to string
-								if jsonBytes, err := json.Marshal(value); err == nil {
-									event.DestinationFolder = string(jsonBytes)
-								}
-							}
-						}
+		// Stop reading if the consumer is done
+		if !yield(event) {
+			logger.Debugf("Stopped reading %s after %d events", source, count)
+			return
+		}
+	}
 
-						// Look for 'Folders' array which often contains 'FolderItems' (Emails)
-						if keyLower == "folders" {
-							if folders, ok := value.([]interface{}); ok {
-								for _, f := range folders {
-									if folderMap, ok := f.(map[string]interface{}); ok {
-										if items, ok := folderMap["FolderItems"].([]interface{}); ok {
-											for _, item := range items {
-												if itemMap, ok := item.(map[string]interface{}); ok {
-													email := models.EmailItem{
-														ID:                fmt.Sprint(itemMap["Id"]),
-														Subject:           fmt.Sprint(itemMap["Subject"]),
-														InternetMessageID: fmt.Sprint(itemMap["InternetMessageId"]),
-													}
-													// Handle numeric size conversion
-													if size, ok := itemMap["SizeInBytes"].(float64); ok {
-														email.SizeInBytes = int64(size)
-													}
-													event.Emails = append(event.Emails, email)
-												}
-											}
-										}
-									}
-								}
-							}
-						}
+	logger.Debugf("Parsed %d events from CSV", count)
+}
 
-						// Check if this record is a File/SharePoint operation
-						if keyLower == "sourcefilename" && value != nil {
-							file := models.FileItem{
-								FileName: fmt.Sprint(value),
-							}
-							if ext, ok := auditMap["SourceFileExtension"].(string); ok {
-								file.FileExtension = ext
-							}
-							if site, ok := auditMap["SiteUrl"].(string); ok {
-								file.SiteURL = site
-							}
-							if obj, ok := auditMap["ObjectId"].(string); ok {
-								file.ObjectID = obj
-							}
-							event.Files = append(event.Files, file)
-						}
-					}
-				}
-			}
+// Builds a normalised event from a single CSV record
+func parseRecord(filePath string, headerMap map[string]int, record []string) (models.PurviewEvent, []string) {
+	columns := make(map[string]string, len(headerMap))
+	for columnName, index := range headerMap {
+		// Ensure loop doesn't go out of bounds if the record has fewer columns than the header
+		if index < len(record) {
+			columns[columnName] = record[index]
 		}
-
-		// Append the event to the slice
-		events = append(events, event)
 	}
 
-	logger.Debugf("Parsed %d events from CSV", len(events))
-	return events
+	return newEvent(filePath, columns, nil)
 }
@@ -0,0 +1,246 @@
+package parser
+
+import (
+	// Standard library dependencies
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+
+	// Internal dependencies
+	"CloudCutter/models"
+)
+
+// Entra ID portal CSV columns mapped to their Graph / SigninLogs field names
+// Nested Graph fields use dotted names so CSV & JSON exports expose the same keys
+var signInColumnNames = map[string]string{
+	"date (utc)":                             "createddatetime",
+	"date":                                   "createddatetime",
+	"request id":                             "id",
+	"user agent":                             "useragent",
+	"correlation id":                         "correlationid",
+	"user id":                                "userid",
+	"user":                                   "userdisplayname",
+	"username":                               "userprincipalname",
+	"user type":                              "usertype",
+	"cross tenant access type":               "crosstenantaccesstype",
+	"incoming token type":                    "incomingtokentype",
+	"authentication protocol":                "authenticationprotocol",
+	"unique token identifier":                "uniquetokenidentifier",
+	"application":                            "appdisplayname",
+	"application id":                         "appid",
+	"resource":                               "resourcedisplayname",
+	"resource id":                            "resourceid",
+	"home tenant id":                         "hometenantid",
+	"home tenant name":                       "hometenantname",
+	"ip address":                             "ipaddress",
+	"location":                               "location",
+	"status":                                 "status",
+	"sign-in error code":                     "resulttype",
+	"failure reason":                         "resultdescription",
+	"client app":                             "clientappused",
+	"device id":                              "devicedetail.deviceid",
+	"browser":                                "devicedetail.browser",
+	"operating system":                       "devicedetail.operatingsystem",
+	"compliant":                              "devicedetail.iscompliant",
+	"managed":                                "devicedetail.ismanaged",
+	"join type":                              "devicedetail.trusttype",
+	"multifactor authentication result":      "mfaresult",
+	"multifactor authentication auth method": "mfadetail.authmethod",
+	"multifactor authentication auth detail": "mfadetail.authdetail",
+	"authentication requirement":             "authenticationrequirement",
+	"sign-in identifier":                     "signinidentifier",
+	"session id":                             "sessionid",
+	"ip address (seen by resource)":          "ipaddressfromresourceprovider",
+	"autonomous system  number":              "autonomoussystemnumber",
+	"autonomous system number":               "autonomoussystemnumber",
+	"flagged for review":                     "flaggedforreview",
+	"token issuer type":                      "tokenissuertype",
+	"token issuer name":                      "tokenissuername",
+	"latency":                                "processingtimeinmilliseconds",
+	"conditional access":                     "conditionalaccessstatus",
+}
+
+// Checks whether CSV headers belong to an Entra ID sign-in log export
+func isSignInHeader(headerMap map[string]int) bool {
+	if _, ok := headerMap["auditdata"]; ok {
+		return false
+	}
+
+	_, hasRequestID := headerMap["request id"]
+	_, hasCorrelationID := headerMap["correlation id"]
+	_, hasIPAddress := headerMap["ip address"]
+
+	return hasIPAddress && (hasRequestID || hasCorrelationID)
+}
+
+// Checks whether a JSON record is an Entra ID sign-in (Graph signIn resource or SigninLogs table row)
+func isSignInRecord(properties map[string]json.RawMessage) bool {
+	keys := make(map[string]bool, len(properties))
+	for key := range properties {
+		keys[strings.ToLower(key)] = true
+	}
+
+	if keys["auditdata"] || keys["workload"] || keys["operation"] {
+		return false
+	}
+
+	return (keys["createddatetime"] || keys["timegenerated"]) && keys["userprincipalname"] && (keys["appdisplayname"] || keys["ipaddress"])
+}
+
+// Builds a normalised event from a single Entra ID portal CSV record
+func parseSignInRecord(source string, headerMap map[string]int, record []string) (models.PurviewEvent, []string) {
+	fields := make(map[string]interface{}, len(headerMap))
+	for columnName, index := range headerMap {
+		// Ensure loop doesn't go out of bounds if the record has fewer columns than the header
+		if index >= len(record) {
+			continue
+		}
+
+		if fieldName, ok := signInColumnNames[columnName]; ok {
+			fields[fieldName] = record[index]
+		} else {
+			fields[columnName] = record[index]
+		}
+	}
+
+	return newSignInEvent(source, fields)
+}
+
+// Builds a normalised event from a single Entra ID sign-in JSON record
+func parseSignInJSON(source string, properties map[string]json.RawMessage) (models.PurviewEvent, []string) {
+	fields := make(map[string]interface{}, len(properties))
+	for key, raw := range properties {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err == nil {
+			fields[strings.ToLower(key)] = value
+		}
+	}
+
+	// Expand the nested Graph objects into the dotted names used by the CSV export
+	nested := make(map[string]interface{})
+	for key, value := range fields {
+		flatten(key, value, nested)
+	}
+	maps.Copy(fields, nested)
+
+	// Graph reports the result in status, the SigninLogs table in ResultType
+	if _, ok := fields["resulttype"]; !ok {
+		if errorCode, ok := fields["status.errorcode"]; ok {
+			fields["resulttype"] = fmt.Sprint(errorCode)
+		}
+	}
+	if _, ok := fields["resultdescription"]; !ok {
+		if reason, ok := fields["status.failurereason"]; ok {
+			fields["resultdescription"] = reason
+		}
+	}
+	if _, ok := fields["createddatetime"]; !ok {
+		fields["createddatetime"] = fields["timegenerated"]
+	}
+
+	return newSignInEvent(source, fields)
+}
+
+// Maps normalised sign-in fields onto the event model
+// Any values that couldn't be parsed are returned as problems alongside the partial event
+func newSignInEvent(source string, fields map[string]interface{}) (models.PurviewEvent, []string) {
+	var problems []string
+
+	event := models.PurviewEvent{
+		SourceFile:  source,
+		LogSource:   models.LogSourceSignIn,
+		M365Service: "AzureActiveDirectory",
+		RawData:     make(map[string]interface{}),
+		AuditData:   make(map[string]interface{}),
+		Flattened:   make(map[string]interface{}),
+	}
+
+	for key, value := range fields {
+		event.RawData[key] = value
+		event.Flattened[key] = value
+	}
+
+	text := func(key string) string {
+		if value, ok := fields[key]; ok && value != nil {
+			return strings.TrimSpace(fmt.Sprint(value))
+		}
+		return ""
+	}
+
+	event.RecordID = text("id")
+	if timestamp := text("createddatetime"); timestamp != "" {
+		if err := setTimestamp(&event, timestamp); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	event.UserID = text("userprincipalname")
+	event.ClientIP = text("ipaddress")
+	event.ClientAppName = text("appdisplayname")
+	event.Client = text("clientappused")
+	event.UserAgent = text("useragent")
+	event.CorrelationID = text("correlationid")
+	event.SessionID = text("sessionid")
+	event.Organisation = text("hometenantname")
+
+	// Portal exports give the location as text, Graph as an object
+	if location, ok := fields["location"].(map[string]interface{}); ok {
+		var parts []string
+		for _, key := range []string{"city", "state", "countryOrRegion"} {
+			if part, ok := location[key].(string); ok && part != "" {
+				parts = append(parts, part)
+			}
+		}
+		event.Location = strings.Join(parts, ", ")
+	} else {
+		event.Location = text("location")
+	}
+
+	// Conditional access status: the portal CSV's Conditional Access column, Graph's conditionalAccessStatus
+	event.ConditionalAccess = text("conditionalaccessstatus")
+
+	// Result: the portal CSV has a Status column, Graph an error code of 0 for success
+	switch status := fields["status"].(type) {
+	case string:
+		event.ResultStatus = status
+	default:
+		if code := text("resulttype"); code == "0" {
+			event.ResultStatus = "Success"
+		} else if code != "" {
+			event.ResultStatus = "Failure"
+		}
+	}
+
+	// Record sign-ins with the same operation names Purview uses for Entra ID logons
+	if strings.EqualFold(event.ResultStatus, "Success") {
+		event.Operation = "UserLoggedIn"
+	} else {
+		event.Operation = "UserLoginFailed"
+	}
+
+	// MFA: the portal CSV has a result column, Graph lists each authentication step
+	event.MFAResult = text("mfaresult")
+	if event.MFAResult == "" && strings.EqualFold(text("authenticationrequirement"), "multiFactorAuthentication") {
+		if steps, ok := fields["authenticationdetails"].([]interface{}); ok && len(steps) > 0 {
+			if lastStep, ok := steps[len(steps)-1].(map[string]interface{}); ok {
+				event.MFAResult = fmt.Sprint(lastStep["authenticationStepResultDetail"])
+			}
+		}
+	}
+
+	// Interactive or non-interactive: Graph has a flag, portal exports are split by file name
+	if interactive, ok := fields["isinteractive"].(bool); ok {
+		if interactive {
+			event.EventSource = "Interactive"
+		} else {
+			event.EventSource = "NonInteractive"
+		}
+	} else if name := strings.ToLower(filepath.Base(source)); strings.Contains(name, "noninteractive") {
+		event.EventSource = "NonInteractive"
+	} else if strings.Contains(name, "interactive") {
+		event.EventSource = "Interactive"
+	}
+
+	return event, problems
+}
@@ -0,0 +1,386 @@
+package parser
+
+import (
+	// Standard library dependencies
+	"container/heap"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/logger"
+	"CloudCutter/models"
+)
+
+// File extensions picked up when a directory is given as input
+var supportedExtensions = []string{".csv", ".json", ".jsonl", ".gz", ".zip"}
+
+// Maximum number of events read ahead when working out the order of an export
+const directionLookahead = 1000
+
+// ExpandPaths resolves files, directories & glob patterns into a sorted list of export files
+func ExpandPaths(inputs []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+
+	addPath := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, input := range inputs {
+		// Expand glob patterns, otherwise treat the input as a literal path
+		matches := []string{input}
+		if strings.ContainsAny(input, "*?[") {
+			var err error
+			matches, err = filepath.Glob(input)
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob pattern '%s': %v", input, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match '%s'", input)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open input: %v", err)
+			}
+
+			// Files are always used, even without a known extension
+			if !info.IsDir() {
+				addPath(match)
+				continue
+			}
+
+			// Directories are searched for supported exports
+			var found []string
+			err = filepath.WalkDir(match, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !entry.IsDir() && slices.Contains(supportedExtensions, strings.ToLower(filepath.Ext(path))) {
+					found = append(found, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read directory '%s': %v", match, err)
+			}
+			if len(found) == 0 {
+				return nil, fmt.Errorf("no exports found in directory '%s'", match)
+			}
+
+			slices.Sort(found)
+			for _, path := range found {
+				addPath(path)
+			}
+		}
+	}
+
+	logger.Debugf("Resolved %d input files", len(paths))
+	return paths, nil
+}
+
+// Stream reads events from a set of exports
+// Reading stops at the first error that can't be skipped, which is available from Err
+type Stream struct {
+	paths  []string
+	reader *reader
+}
+
+// NewStream creates a stream over the given export files
+func NewStream(paths []string, options Options) *Stream {
+	return &Stream{paths: paths, reader: &reader{options: options}}
+}
+
+// Err returns the error that stopped the stream, if any
+func (stream *Stream) Err() error {
+	return stream.reader.err
+}
+
+// Quiet returns a separate stream over the same exports for reading them again
+// Problems are left for the main read to report, so they aren't counted twice
+func (stream *Stream) Quiet() *Stream {
+	return NewStream(stream.paths, Options{})
+}
+
+// Latest returns the timestamp of the newest event, reading every export once
+func (stream *Stream) Latest() (time.Time, error) {
+	quiet := stream.Quiet()
+
+	var latest time.Time
+	for event := range quiet.Events() {
+		if timestamp, err := time.Parse(time.RFC3339, event.Timestamp); err == nil && timestamp.After(latest) {
+			latest = timestamp
+		}
+	}
+	logger.Debugf("Newest event is at %v", latest)
+
+	return latest, quiet.Err()
+}
+
+// Events streams events from every export, merged chronologically & de-duplicated by RecordID
+// Exports are expected to be sorted by time (Purview writes them newest first) & the merged
+// stream follows the same direction as most of the inputs, so only the head of each file is held in memory
+func (stream *Stream) Events() iter.Seq[models.PurviewEvent] {
+	return func(yield func(models.PurviewEvent) bool) {
+		var sources []*mergeSource
+		defer func() {
+			for _, source := range sources {
+				source.stop()
+			}
+		}()
+
+		for _, path := range stream.paths {
+			streams, err := stream.reader.fileStreams(path)
+			if err != nil {
+				stream.reader.fail(err)
+				return
+			}
+
+			for index, fileStream := range streams {
+				name := path
+				if len(streams) > 1 {
+					name = fmt.Sprintf("%s (%d of %d)", path, index+1, len(streams))
+				}
+				sources = append(sources, newMergeSource(name, fileStream))
+			}
+		}
+
+		// No exports to read
+		if len(sources) == 0 {
+			return
+		}
+
+		// Follow the direction most exports are sorted in, ascending if none has two distinct timestamps
+		directions := make([]int, len(sources))
+		ascending, descending := 0, 0
+		for index, source := range sources {
+			directions[index] = source.direction()
+			switch directions[index] {
+			case 1:
+				ascending++
+			case -1:
+				descending++
+			}
+		}
+		merged := &mergeHeap{descending: descending > ascending}
+		if merged.descending {
+			logger.Debugf("Merging %d exports in descending order", len(sources))
+		} else {
+			logger.Debugf("Merging %d exports in ascending order", len(sources))
+		}
+
+		// Exports sorted the other way can only be merged once they have been read in full
+		for index, source := range sources {
+			if (directions[index] < 0) != merged.descending && directions[index] != 0 {
+				stream.reader.warn(fmt.Sprintf("%s is sorted the other way to the other exports, so it was read into memory to merge it", source.name))
+				source.sort(merged.descending)
+			}
+		}
+
+		for _, source := range sources {
+			source.descending = merged.descending
+			if _, ok := source.peek(); ok {
+				merged.sources = append(merged.sources, source)
+			}
+		}
+		heap.Init(merged)
+
+		events := func(yield func(models.PurviewEvent) bool) {
+			// Stop as soon as any export fails, rather than carrying on with the others
+			for merged.Len() > 0 && !stream.reader.stopped() {
+				source := merged.sources[0]
+				event, _ := source.peek()
+				source.advance()
+
+				// Events after one that goes back in time can't be put back in order without reading everything
+				if !source.unordered && source.outOfOrder(event) {
+					source.unordered = true
+					stream.reader.warn(fmt.Sprintf("%s isn't sorted by time (RecordID %s at %s), so the merged events around it are out of order", source.name, event.RecordID, event.Timestamp))
+				}
+				if event.Timestamp != "" {
+					source.last = event.Timestamp
+				}
+
+				// Re-position the source by its next event or drop it once exhausted
+				if _, ok := source.peek(); ok {
+					heap.Fix(merged, 0)
+				} else {
+					heap.Pop(merged)
+				}
+
+				if !yield(event) {
+					return
+				}
+			}
+		}
+
+		for event := range deduplicate(events) {
+			if !yield(event) {
+				return
+			}
+		}
+	}
+}
+
+// deduplicate drops repeated RecordIDs, wherever in the stream the repeat appears
+// Only the IDs are remembered, so memory grows with the number of records rather than their size
+func deduplicate(events iter.Seq[models.PurviewEvent]) iter.Seq[models.PurviewEvent] {
+	return func(yield func(models.PurviewEvent) bool) {
+		seen := make(map[string]bool)
+		dropped := 0
+
+		for event := range events {
+			if event.RecordID != "" {
+				if seen[event.RecordID] {
+					dropped++
+					continue
+				}
+				seen[event.RecordID] = true
+			}
+
+			if !yield(event) {
+				break
+			}
+		}
+
+		logger.Debugf("Dropped %d duplicate events", dropped)
+	}
+}
+
+// mergeSource wraps a single export stream with lookahead
+type mergeSource struct {
+	name       string
+	next       func() (models.PurviewEvent, bool)
+	stop       func()
+	pending    []models.PurviewEvent
+	done       bool
+	descending bool   // Direction of the merge the export is part of
+	last       string // Timestamp of the last event taken from the export
+	unordered  bool   // Set once the export has been found out of order
+}
+
+func newMergeSource(name string, events iter.Seq[models.PurviewEvent]) *mergeSource {
+	next, stop := iter.Pull(events)
+	return &mergeSource{name: name, next: next, stop: stop}
+}
+
+// fill reads ahead until at least n events are pending or the export is exhausted
+func (source *mergeSource) fill(n int) {
+	for !source.done && len(source.pending) < n {
+		event, ok := source.next()
+		if !ok {
+			source.done = true
+			return
+		}
+		source.pending = append(source.pending, event)
+	}
+}
+
+// peek returns the next event without consuming it
+func (source *mergeSource) peek() (models.PurviewEvent, bool) {
+	source.fill(1)
+	if len(source.pending) == 0 {
+		return models.PurviewEvent{}, false
+	}
+
+	return source.pending[0], true
+}
+
+// advance consumes the next event
+func (source *mergeSource) advance() {
+	source.fill(1)
+	if len(source.pending) > 0 {
+		source.pending[0] = models.PurviewEvent{}
+		source.pending = source.pending[1:]
+	}
+}
+
+// outOfOrder reports whether an event taken from the export goes back against the merge direction
+func (source *mergeSource) outOfOrder(event models.PurviewEvent) bool {
+	if source.last == "" || event.Timestamp == "" {
+		return false
+	}
+	if source.descending {
+		return event.Timestamp > source.last
+	}
+	return event.Timestamp < source.last
+}
+
+// direction reports whether the export is ascending (1), descending (-1) or unknown (0)
+// Events are read ahead until a second distinct timestamp is found
+func (source *mergeSource) direction() int {
+	first := ""
+	for index := 0; index < directionLookahead; index++ {
+		source.fill(index + 1)
+		if len(source.pending) <= index {
+			return 0
+		}
+
+		current := source.pending[index].Timestamp
+		switch {
+		case current == "" || current == first:
+			continue
+		case first == "":
+			first = current
+		case current > first:
+			return 1
+		default:
+			return -1
+		}
+	}
+
+	return 0
+}
+
+// sort reads the rest of the export & sorts it in the merge direction
+func (source *mergeSource) sort(descending bool) {
+	source.fill(int(^uint(0) >> 1))
+	slices.SortStableFunc(source.pending, func(a, b models.PurviewEvent) int {
+		if descending {
+			return strings.Compare(b.Timestamp, a.Timestamp)
+		}
+		return strings.Compare(a.Timestamp, b.Timestamp)
+	})
+}
+
+// mergeHeap orders sources by the timestamp of their next event
+type mergeHeap struct {
+	sources    []*mergeSource
+	descending bool
+}
+
+func (merged *mergeHeap) Len() int { return len(merged.sources) }
+
+func (merged *mergeHeap) Less(i, j int) bool {
+	left, _ := merged.sources[i].peek()
+	right, _ := merged.sources[j].peek()
+	if merged.descending {
+		return left.Timestamp > right.Timestamp
+	}
+	return left.Timestamp < right.Timestamp
+}
+
+func (merged *mergeHeap) Swap(i, j int) {
+	merged.sources[i], merged.sources[j] = merged.sources[j], merged.sources[i]
+}
+
+func (merged *mergeHeap) Push(x any) {
+	merged.sources = append(merged.sources, x.(*mergeSource))
+}
+
+func (merged *mergeHeap) Pop() any {
+	last := merged.sources[len(merged.sources)-1]
+	merged.sources = merged.sources[:len(merged.sources)-1]
+	return last
+}
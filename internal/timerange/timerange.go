@@ -0,0 +1,281 @@
+package timerange
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"iter"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/logger"
+	"CloudCutter/models"
+)
+
+// Location that times without a zone are read in & events are shown in, set by --timezone
+var Location = time.UTC
+
+// Relative expressions: an offset from the newest event (-48h, latest-7d) or from the current time (now, now-2h)
+var relativePattern = regexp.MustCompile(`^(?i)(now|latest)?\s*(?:([+-])\s*((?:\d+[smhdw])+))?$`)
+
+// A single number & unit within an offset, e.g. the 12h in 1d12h
+var offsetPartPattern = regexp.MustCompile(`(\d+)([smhdw])`)
+
+// A whole duration made of offset parts, e.g. 15m or 1d12h
+var durationPattern = regexp.MustCompile(`^(?:\d+[smhdw])+$`)
+
+// Length of the units an offset can be written in
+var offsetUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// Absolute formats accepted, most specific first
+var absoluteFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Anchors a time expression can be relative to
+const (
+	anchorNone   = iota // Absolute time
+	anchorLatest        // Newest event in the loaded exports
+	anchorNow           // Current time
+)
+
+// Expression is a point in time written as an absolute time or relative to the newest event or now
+type Expression struct {
+	text     string
+	absolute time.Time
+	anchor   int
+	offset   time.Duration
+	dateOnly bool // Absolute date without a time, which covers the whole day
+}
+
+// IsRelative checks whether text is a relative expression such as -48h, now or now-2h
+func IsRelative(text string) bool {
+	text = strings.TrimSpace(text)
+	matches := relativePattern.FindStringSubmatch(text)
+	return text != "" && matches != nil && (matches[1] != "" || matches[2] != "")
+}
+
+// Parse reads an absolute time (RFC3339, date & time, or date) or a relative expression
+// Absolute times without a zone are read in Location
+func Parse(text string) (Expression, error) {
+	text = strings.TrimSpace(text)
+	expression := Expression{text: text}
+
+	if IsRelative(text) {
+		matches := relativePattern.FindStringSubmatch(text)
+		expression.anchor = anchorLatest
+		if strings.EqualFold(matches[1], "now") {
+			expression.anchor = anchorNow
+		}
+
+		// Units are matched in any case, like the rest of the expression
+		for _, part := range offsetPartPattern.FindAllStringSubmatch(strings.ToLower(matches[3]), -1) {
+			count, err := strconv.Atoi(part[1])
+			if err != nil {
+				return Expression{}, fmt.Errorf("invalid time offset '%s': %v", text, err)
+			}
+			expression.offset += time.Duration(count) * offsetUnits[part[2]]
+		}
+		if matches[2] == "-" {
+			expression.offset = -expression.offset
+		}
+
+		return expression, nil
+	}
+
+	for _, format := range absoluteFormats {
+		if parsed, err := time.ParseInLocation(format, text, Location); err == nil {
+			expression.absolute = parsed
+			expression.dateOnly = format == "2006-01-02"
+			return expression, nil
+		}
+	}
+
+	return Expression{}, fmt.Errorf("invalid time '%s' (expected RFC3339, YYYY-MM-DD [hh:mm[:ss]], or a relative time such as -48h, -7d or now-2h)", text)
+}
+
+// ParseDuration reads a length of time in the units offsets use, such as 15m, 1h, 1d or 1w
+func ParseDuration(text string) (time.Duration, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if !durationPattern.MatchString(text) {
+		return 0, fmt.Errorf("invalid duration '%s' (expected a number & unit such as 15m, 1h, 1d or 1w)", text)
+	}
+
+	var duration time.Duration
+	for _, part := range offsetPartPattern.FindAllStringSubmatch(text, -1) {
+		count, err := strconv.Atoi(part[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s': %v", text, err)
+		}
+		duration += time.Duration(count) * offsetUnits[part[2]]
+	}
+
+	if duration <= 0 {
+		return 0, fmt.Errorf("invalid duration '%s': must be longer than zero", text)
+	}
+
+	return duration, nil
+}
+
+// NeedsLatest reports whether the expression is relative to the newest event
+func (expression Expression) NeedsLatest() bool {
+	return expression.anchor == anchorLatest
+}
+
+// Resolve returns the time the expression refers to, given the newest event & the current time
+func (expression Expression) Resolve(latest time.Time, now time.Time) time.Time {
+	switch expression.anchor {
+	case anchorLatest:
+		return latest.Add(expression.offset)
+	case anchorNow:
+		return now.Add(expression.offset)
+	}
+
+	return expression.absolute
+}
+
+func (expression Expression) String() string {
+	return expression.text
+}
+
+// DayEnd reads a date on its own, in Location, & returns the last moment of that day
+// Used for the upper end of a range, which includes the whole of a day given on its own
+func DayEnd(text string) (time.Time, bool) {
+	day, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(text), Location)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return endOfDay(day), true
+}
+
+// Returns the last moment of the day starting at midnight
+func endOfDay(midnight time.Time) time.Time {
+	return midnight.Add(24*time.Hour - time.Nanosecond)
+}
+
+// Window restricts events to those between two times, both inclusive
+// A date on its own as the end of the window includes the whole of that day
+type Window struct {
+	from  *Expression
+	to    *Expression
+	start time.Time
+	end   time.Time
+}
+
+// NewWindow parses the --from & --to expressions, either of which may be empty
+func NewWindow(from string, to string) (*Window, error) {
+	window := &Window{}
+
+	if from != "" {
+		expression, err := Parse(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --from: %v", err)
+		}
+		window.from = &expression
+	}
+
+	if to != "" {
+		expression, err := Parse(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --to: %v", err)
+		}
+		window.to = &expression
+	}
+
+	return window, nil
+}
+
+// Enabled reports whether either end of the window is set
+func (window *Window) Enabled() bool {
+	return window.from != nil || window.to != nil
+}
+
+// NeedsLatest reports whether either end of the window is relative to the newest event
+func (window *Window) NeedsLatest() bool {
+	return window.from != nil && window.from.NeedsLatest() || window.to != nil && window.to.NeedsLatest()
+}
+
+// Resolve fixes the window's times, given the newest event & the current time
+func (window *Window) Resolve(latest time.Time, now time.Time) {
+	if window.from != nil {
+		window.start = window.from.Resolve(latest, now)
+	}
+
+	if window.to != nil {
+		window.end = window.to.Resolve(latest, now)
+		if window.to.dateOnly {
+			window.end = endOfDay(window.end)
+		}
+	}
+
+	logger.Debugf("Time window: %v to %v", window.start, window.end)
+}
+
+// Contains checks whether a time falls within the window
+func (window *Window) Contains(timestamp time.Time) bool {
+	if window.from != nil && timestamp.Before(window.start) {
+		return false
+	}
+	if window.to != nil && timestamp.After(window.end) {
+		return false
+	}
+
+	return true
+}
+
+// Apply drops events outside the window, along with events without a timestamp
+func (window *Window) Apply(events iter.Seq[models.PurviewEvent]) iter.Seq[models.PurviewEvent] {
+	if !window.Enabled() {
+		return events
+	}
+
+	return func(yield func(models.PurviewEvent) bool) {
+		for event := range events {
+			timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+			if err != nil || !window.Contains(timestamp) {
+				continue
+			}
+
+			if !yield(event) {
+				return
+			}
+		}
+	}
+}
+
+// Localise converts each event's Timestamp, Date & Time to Location, keeping the UTC time in TimestampUTC
+// Events are localised after merging, which relies on the UTC timestamps sorting as text
+func Localise(events iter.Seq[models.PurviewEvent]) iter.Seq[models.PurviewEvent] {
+	if Location == time.UTC {
+		return events
+	}
+
+	return func(yield func(models.PurviewEvent) bool) {
+		for event := range events {
+			if timestamp, err := time.Parse(time.RFC3339, event.TimestampUTC); err == nil {
+				local := timestamp.In(Location)
+				event.Timestamp = local.Format(time.RFC3339)
+				event.Date = local.Format("2006-01-02")
+				event.Time = local.Format("15:04:05")
+			}
+
+			if !yield(event) {
+				return
+			}
+		}
+	}
+}
@@ -3,24 +3,43 @@ package main
 import (
 	// Standard library dependencies
 	"fmt"
+	"io"
+	"iter"
 	"os"
+	"strings"
+	"time"
 
 	// Internal dependencies
+	"CloudCutter/internal/library"
 	"CloudCutter/internal/logger"
 	"CloudCutter/internal/output"
 	"CloudCutter/internal/parser"
+	"CloudCutter/internal/timerange"
+	"CloudCutter/models"
 	"CloudCutter/tools/analysis"
+	"CloudCutter/tools/schema"
 	"CloudCutter/tools/search"
+	"CloudCutter/tools/sequence"
+	"CloudCutter/tools/stats"
+	"CloudCutter/tools/surrounding"
 
 	// External dependencies
 	"github.com/spf13/cobra"
 )
 
 // Global variables for flags
-var csvFile string
+var inputFiles []string
 var debug bool
 var logFile string
 var outputFile string
+var strict bool
+var errorReport string
+var fromTime string
+var toTime string
+var timezone string
+var contextBefore string
+var contextAfter string
+var contextSameIP bool
 
 func main() {
 	// Execute the root command & catch any errors
@@ -36,6 +55,8 @@ func rootCommand() *cobra.Command {
 		Use:   "CloudCutter",
 		Short: "A Purview Log Analysis Tool",
 		Long:  `Purview Analyser is a tool inspired by Chainsaw to analyse Microsoft Purview CSV exports using Sigma rules.`,
+		// Errors are printed once by main
+		SilenceErrors: true,
 	}
 
 	// Disable the default help command
@@ -46,16 +67,21 @@ func rootCommand() *cobra.Command {
 	})
 
 	// Define persistent flags
-	command.PersistentFlags().StringVarP(&csvFile, "file", "f", "", "Path to the CSV file to process")
+	command.PersistentFlags().StringSliceVarP(&inputFiles, "file", "f", nil, "Path to the CSV file(s) to process (repeatable, accepts directories & glob patterns)")
 	command.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
 	command.PersistentFlags().StringVarP(&logFile, "log-file", "", "", "Path to the log file to write debug logs to")
 	command.PersistentFlags().StringVarP(&outputFile, "output", "o", "", "Output file to write the findings to (CSV)")
-
-	// Define required flags
-	command.MarkPersistentFlagRequired("file")
+	command.PersistentFlags().BoolVarP(&strict, "strict", "", false, "Abort on the first malformed row instead of skipping it")
+	command.PersistentFlags().StringVarP(&errorReport, "error-report", "", "", "Path to a CSV file listing skipped or partially parsed rows")
+	command.PersistentFlags().StringVarP(&fromTime, "from", "", "", "Only include events at or after this time (RFC3339, YYYY-MM-DD [hh:mm[:ss]], -48h relative to the newest event, or now-48h)")
+	command.PersistentFlags().StringVarP(&toTime, "to", "", "", "Only include events at or before this time (same forms as --from, a date includes the whole day)")
+	command.PersistentFlags().StringVarP(&timezone, "timezone", "", "UTC", "IANA timezone to show times in & read query times without a zone in (e.g. Europe/London)")
 
 	// Define pre-run function
-	command.PersistentPreRun = func(_ *cobra.Command, _ []string) {
+	command.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		// Flags have been parsed, so any further error isn't a usage problem
+		cmd.SilenceUsage = true
+
 		// Enable debug logging if the debug flag is set
 		logger.Enabled = debug
 
@@ -69,11 +95,22 @@ func rootCommand() *cobra.Command {
 				logger.Debugf("Logging to file: %s", logFile)
 			}
 		}
+
+		// Load the timezone used to show & read times
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone '%s': %v", timezone, err)
+		}
+		timerange.Location = location
+
+		return nil
 	}
 
 	// Add subcommands to the root command
 	command.AddCommand(analysisCommand())
 	command.AddCommand(searchCommand())
+	command.AddCommand(statsCommand())
+	command.AddCommand(sequenceCommand())
 
 	return command
 }
@@ -85,19 +122,46 @@ func searchCommand() *cobra.Command {
 	var outputFormat string
 	var limit int
 	var countOnly bool
+	var sortFields []string
+	var fields []string
+	var distinct []string
+	var keywords []string
+	var queryFile string
+	var savedQuery string
+	var parameters map[string]string
+	var libraryPaths []string
+	var listSaved bool
 
 	// Define command
 	var command = &cobra.Command{
 		Use:   "search",
 		Short: "Search for a specific term in the CSV file",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return executeSearch(cmd, args, searchQuery, listColumns, outputFormat, limit, countOnly)
+			if listSaved {
+				saved, err := loadLibrary(libraryPaths)
+				if err != nil {
+					return err
+				}
+				output.PrintSavedQueries(saved.Queries())
+				return nil
+			}
+
+			query, saved, err := buildQuery(searchQuery, args, queryFile, savedQuery, parameters, libraryPaths)
+			if err != nil {
+				return err
+			}
+
+			return executeSearch(cmd, query, saved, keywords, listColumns, outputFormat, limit, countOnly, sortFields, fields, distinct)
 		},
 	}
 
 	// Define flags
 	var queryHelpText = "Search query to filter events. \n" +
-		"Operators: ==, !=, >, <, >=, <=, LIKE, AND, OR \n" +
+		"Free text: a word or 'quoted string' on its own matches any value, e.g. '203.0.113.7' AND Operation == 'UserLoggedIn' \n" +
+		"Operators: ==, !=, >, <, >=, <=, [NOT] LIKE, [NOT] MATCHES (=~), [NOT] IN (a, b), [NOT] BETWEEN a AND b, [NOT] IN_CIDR, IS [NOT] NULL, EXISTS, AND, OR, NOT \n" +
+		"Functions: is_private(ip), is_loopback(ip), is_public(ip), len(x), lower(x), upper(x), domain(email), \n" +
+		"           startswith(x, prefix), endswith(x, suffix), contains(x, text), split(x, separator) \n" +
+		"Arithmetic: +, -, *, / written apart from their values, e.g. Emails.SizeInBytes / 1024 > 500 \n" +
 		"Fields:    Operation, UserID, ClientIP, etc. \n" +
 		"Examples:\n" +
 		"	-q \"Operation == 'MailItemsAccessed'\" \n" +
@@ -105,55 +169,364 @@ func searchCommand() *cobra.Command {
 		"	-q \"ClientIP != '[IP_ADDRESS]' AND (Operation == 'FileModified' OR Operation == 'MailItemAccessed')\" "
 
 	command.Flags().StringVarP(&searchQuery, "query", "q", "", queryHelpText)
-	command.Flags().BoolVarP(&listColumns, "list", "", false, "List all available columns in the CSV")
-	command.Flags().StringVarP(&outputFormat, "format", "", "log", "Format to output the events in")
+	command.Flags().StringVarP(&queryFile, "query-file", "", "", "Read the search query from a file (- for stdin), avoiding shell quoting problems")
+	command.Flags().StringVarP(&savedQuery, "saved", "", "", "Run a saved query from the query library by name (combined with -q using AND)")
+	command.Flags().StringToStringVarP(&parameters, "param", "p", nil, "Value for a ${name} placeholder in the saved query (e.g. --param user=admin@contoso.com)")
+	command.Flags().StringSliceVarP(&libraryPaths, "library", "", nil, "YAML query library file(s) or directories, read after "+libraryLocation())
+	command.Flags().BoolVarP(&listSaved, "list-saved", "", false, "List the saved queries in the query library with their parameters & descriptions")
+	command.Flags().StringArrayVarP(&keywords, "keyword", "k", nil, "Search for text anywhere in the row & its AuditData, reporting the fields it was found in (repeat to match any of several)")
+	command.Flags().BoolVarP(&listColumns, "list", "", false, "List the fields present in the input files with counts, types, workloads & sample values")
+	command.Flags().StringVarP(&outputFormat, "format", "", "log", "Format to output the events in: log, json (array) or jsonl (one event per line)")
 	command.Flags().IntVarP(&limit, "limit", "l", 0, "Limit the number of events to output")
 	command.Flags().BoolVarP(&countOnly, "count", "c", false, "Count the number of events")
+	command.Flags().StringSliceVarP(&sortFields, "sort", "", nil, "Sort the results by field(s), each optionally followed by :asc or :desc (e.g. Timestamp:desc,UserID)")
+	command.Flags().StringSliceVarP(&fields, "fields", "", nil, "Only output these field(s), including nested paths such as Emails.Subject & AuditData keys")
+	command.Flags().StringSliceVarP(&distinct, "distinct", "", nil, "Output the unique values of field(s) with how often each was seen, instead of the events")
+	addContextFlags(command)
 
 	return command
 }
 
-func executeSearch(_ *cobra.Command, args []string, searchQuery string, listColumns bool, outputFormat string, limit int, countOnly bool) error {
-	// Parse the CSV file & return events
-	events := parser.ParsePurviewCSV(csvFile)
+func executeSearch(_ *cobra.Command, searchQuery string, saved search.Saved, keywords []string, listColumns bool, outputFormat string, limit int, countOnly bool, sortFields []string, fields []string, distinct []string) error {
+	// Validate the time window before any files are read
+	window, err := timerange.NewWindow(fromTime, toTime)
+	if err != nil {
+		return err
+	}
 
-	// List columns from CSV
+	// List the fields present in the input files
 	if listColumns {
-		columns := parser.GetPurviewEventColumns(events, false)
+		// Open the input files
+		stream, report, err := openStream()
+		if err != nil {
+			return err
+		}
 
-		fmt.Println("Available columns in the CSV:")
-		fmt.Println("-----------------------")
+		events, err := applyWindow(stream, window)
+		if err != nil {
+			return closeReport(report, err)
+		}
 
-		for _, column := range columns {
-			fmt.Println(" - ", column)
+		fields, eventCount := schema.Observe(events)
+		if err := stream.Err(); err != nil {
+			return closeReport(report, err)
 		}
+		output.PrintSchema(fields, eventCount)
 
-		return nil
+		return closeReport(report, nil)
 	}
 
 	// Perform search
-	if searchQuery != "" {
-		// If there are positional args, append them to the search query
-		if len(args) > 0 {
-			for _, arg := range args {
-				searchQuery += " " + arg
-			}
+	if searchQuery != "" || saved.Query != "" || len(keywords) > 0 {
+		// Validate the query & sort order before any files are read
+		filter, err := search.NewFilter(searchQuery, keywords, saved)
+		if err != nil {
+			return err
+		}
+		sortKeys, err := output.ParseSortKeys(sortFields)
+		if err != nil {
+			return err
+		}
+		context, err := contextOptions(limit)
+		if err != nil {
+			return err
+		}
+
+		// Open the input files
+		stream, report, err := openStream()
+		if err != nil {
+			return err
+		}
+
+		events, err := applyWindow(stream, window, filter)
+		if err != nil {
+			return closeReport(report, err)
+		}
+
+		// Stream the CSV files & filter the events as they are read
+		filteredEvents := filter.Apply(events)
+
+		// The limit applies to the hits rather than the context around them
+		if context.Enabled() {
+			filteredEvents = surrounding.Add(filteredEvents, timerange.Localise(window.Apply(stream.Quiet().Events())), context)
+			limit = 0
 		}
-		filteredEvents := search.Query(events, searchQuery)
 
 		// Process the results
-		return output.ProcessResults(filteredEvents, output.ResultOptions{
-			Limit:        limit,
-			CountOnly:    countOnly,
-			OutputFormat: outputFormat,
-			OutputFile:   outputFile,
-			IncludeSigma: false,
+		err = output.ProcessResults(filteredEvents, output.ResultOptions{
+			Limit:          limit,
+			CountOnly:      countOnly,
+			OutputFormat:   outputFormat,
+			OutputFile:     outputFile,
+			IncludeSigma:   false,
+			IncludeContext: context.Enabled(),
+			IncludeMatches: filter.HasKeywords(),
+			Sort:           sortKeys,
+			Fields:         fields,
+			Distinct:       distinct,
+			Err:            stream.Err,
 		})
+
+		return closeReport(report, err)
 	}
 
 	return nil
 }
 
+// Put together the search query from -q & any positional args or --query-file, along with any saved query
+// The saved query is kept apart, so only its own placeholders are filled in
+func buildQuery(searchQuery string, args []string, queryFile string, savedQuery string, parameters map[string]string, libraryPaths []string) (string, search.Saved, error) {
+	// If there are positional args, append them to the search query
+	for _, arg := range args {
+		searchQuery += " " + arg
+	}
+
+	// Read the query from a file, or stdin with -
+	if queryFile != "" {
+		if strings.TrimSpace(searchQuery) != "" {
+			return "", search.Saved{}, fmt.Errorf("use either -q or --query-file, not both")
+		}
+
+		var contents []byte
+		var err error
+		if queryFile == "-" {
+			contents, err = io.ReadAll(os.Stdin)
+		} else {
+			contents, err = os.ReadFile(queryFile)
+		}
+		if err != nil {
+			return "", search.Saved{}, fmt.Errorf("failed to read query file: %v", err)
+		}
+		searchQuery = string(contents)
+	}
+
+	if savedQuery == "" {
+		if len(parameters) > 0 {
+			return "", search.Saved{}, fmt.Errorf("--param needs a saved query to fill in (--saved)")
+		}
+		return searchQuery, search.Saved{}, nil
+	}
+
+	// Look up the saved query, which any other query given narrows down
+	saved, err := loadLibrary(libraryPaths)
+	if err != nil {
+		return "", search.Saved{}, err
+	}
+	query, err := saved.Get(savedQuery)
+	if err != nil {
+		return "", search.Saved{}, err
+	}
+	values, err := query.Values(parameters)
+	if err != nil {
+		return "", search.Saved{}, err
+	}
+	logger.Debugf("Running saved query '%s' with parameters %v", query.Name, values)
+
+	return searchQuery, search.Saved{Query: query.Query, Parameters: values}, nil
+}
+
+// Load the query library from the default directory, if it exists, & then any --library paths
+func loadLibrary(libraryPaths []string) (*library.Library, error) {
+	var paths []string
+	if defaultPath := library.DefaultPath(); defaultPath != "" {
+		if _, err := os.Stat(defaultPath); err == nil {
+			paths = append(paths, defaultPath)
+		}
+	}
+
+	return library.Load(append(paths, libraryPaths...))
+}
+
+// Describe where saved queries are loaded from by default for the flag help
+func libraryLocation() string {
+	if defaultPath := library.DefaultPath(); defaultPath != "" {
+		return defaultPath
+	}
+
+	return "the user config directory"
+}
+
+func statsCommand() *cobra.Command {
+	// Variables
+	var searchQuery string
+	var groupBy []string
+	var bucket string
+	var countDistinct string
+	var outputFormat string
+	var limit int
+
+	// Define command
+	var command = &cobra.Command{
+		Use:   "stats",
+		Short: "Group the events matching a query & count them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeStats(cmd, args, searchQuery, groupBy, bucket, countDistinct, outputFormat, limit)
+		},
+	}
+
+	// Define flags
+	command.Flags().StringVarP(&searchQuery, "query", "q", "", "Search query to select the events to group (all events if not set, see search --help)")
+	command.Flags().StringSliceVarP(&groupBy, "by", "b", nil, "Group by field(s), including nested paths such as Emails.Subject & AuditData keys")
+	command.Flags().StringVarP(&bucket, "bucket", "", "", "Also group by time buckets of this length (e.g. 15m, 1h, 1d or 1w)")
+	command.Flags().StringVarP(&countDistinct, "count-distinct", "", "", "Count the distinct values of this field in each group (e.g. ClientIP)")
+	command.Flags().StringVarP(&outputFormat, "format", "", "table", "Format to output the groups in: table, csv, json (array) or jsonl (one group per line)")
+	command.Flags().IntVarP(&limit, "limit", "l", 0, "Limit the number of groups to output")
+
+	return command
+}
+
+func executeStats(_ *cobra.Command, args []string, searchQuery string, groupBy []string, bucket string, countDistinct string, outputFormat string, limit int) error {
+	// Validate the options before any files are read
+	window, err := timerange.NewWindow(fromTime, toTime)
+	if err != nil {
+		return err
+	}
+	if err := output.ValidateStatsFormat(outputFormat); err != nil {
+		return err
+	}
+
+	var bucketWidth time.Duration
+	if bucket != "" {
+		bucketWidth, err = timerange.ParseDuration(bucket)
+		if err != nil {
+			return err
+		}
+	}
+
+	// If there are positional args, append them to the search query
+	for _, arg := range args {
+		searchQuery += " " + arg
+	}
+
+	var filter *search.Filter
+	if searchQuery != "" {
+		filter, err = search.Parse(searchQuery)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Open the input files
+	stream, report, err := openStream()
+	if err != nil {
+		return err
+	}
+
+	var filters []*search.Filter
+	if filter != nil {
+		filters = append(filters, filter)
+	}
+
+	events, err := applyWindow(stream, window, filters...)
+	if err != nil {
+		return closeReport(report, err)
+	}
+	if filter != nil {
+		events = filter.Apply(events)
+	}
+
+	// Group the events & print the statistics for each group
+	groups := stats.Aggregate(events, stats.Options{
+		By:            groupBy,
+		Bucket:        bucketWidth,
+		CountDistinct: countDistinct,
+	})
+
+	err = output.PrintStats(groups, output.StatsOptions{
+		By:            groupBy,
+		Bucket:        bucketWidth > 0,
+		CountDistinct: countDistinct,
+		Limit:         limit,
+		OutputFormat:  outputFormat,
+		OutputFile:    outputFile,
+		Err:           stream.Err,
+	})
+
+	return closeReport(report, err)
+}
+
+func sequenceCommand() *cobra.Command {
+	// Variables
+	var steps []string
+	var join []string
+	var within string
+	var outputFormat string
+	var limit int
+	var countOnly bool
+
+	// Define command
+	var command = &cobra.Command{
+		Use:   "sequence",
+		Short: "Find events matching a series of queries in order for the same user, IP or session",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeSequence(cmd, args, steps, join, within, outputFormat, limit, countOnly)
+		},
+	}
+
+	// Define flags
+	command.Flags().StringArrayVarP(&steps, "step", "s", nil, "Search query for the next step of the sequence (repeat in order, see search --help)")
+	command.Flags().StringSliceVarP(&join, "join", "j", []string{"UserID"}, "Field(s) every event in a chain must share (e.g. UserID,ClientIP or AppAccessContext.AADSessionId)")
+	command.Flags().StringVarP(&within, "within", "w", "1h", "Longest time from the first step to the last (e.g. 30m, 2h or 1d)")
+	command.Flags().StringVarP(&outputFormat, "format", "", "log", "Format to output the chains in: log, json (array) or jsonl (one chain per line)")
+	command.Flags().IntVarP(&limit, "limit", "l", 0, "Limit the number of chains to output")
+	command.Flags().BoolVarP(&countOnly, "count", "c", false, "Count the number of chains")
+	command.MarkFlagRequired("step")
+
+	return command
+}
+
+func executeSequence(_ *cobra.Command, _ []string, steps []string, join []string, within string, outputFormat string, limit int, countOnly bool) error {
+	// Validate the options & every step before any files are read
+	window, err := timerange.NewWindow(fromTime, toTime)
+	if err != nil {
+		return err
+	}
+	if len(join) == 0 {
+		return fmt.Errorf("at least one --join field is required")
+	}
+	withinDuration, err := timerange.ParseDuration(within)
+	if err != nil {
+		return err
+	}
+
+	filters := make([]*search.Filter, len(steps))
+	for index, step := range steps {
+		filters[index], err = search.Parse(step)
+		if err != nil {
+			return fmt.Errorf("step %d: %v", index+1, err)
+		}
+	}
+
+	// Open the input files
+	stream, report, err := openStream()
+	if err != nil {
+		return err
+	}
+
+	events, err := applyWindow(stream, window, filters...)
+	if err != nil {
+		return closeReport(report, err)
+	}
+
+	// Tie the events matching each step into chains
+	chains := sequence.Find(events, sequence.Options{
+		Steps:  filters,
+		Join:   join,
+		Within: withinDuration,
+	})
+
+	// Process the results
+	err = output.ProcessChains(chains, join, output.ResultOptions{
+		Limit:        limit,
+		CountOnly:    countOnly,
+		OutputFormat: outputFormat,
+		OutputFile:   outputFile,
+		Err:          stream.Err,
+	})
+
+	return closeReport(report, err)
+}
+
 func analysisCommand() *cobra.Command {
 	// Variables
 	var sigmaFilePath string
@@ -172,27 +545,145 @@ func analysisCommand() *cobra.Command {
 
 	// Define flags
 	command.Flags().StringVarP(&sigmaFilePath, "sigma", "s", "", "Path to the Sigma files")
-	command.Flags().StringVarP(&outputFormat, "format", "", "log", "Format to output the events in")
+	command.Flags().StringVarP(&outputFormat, "format", "", "log", "Format to output the events in: log, json (array) or jsonl (one event per line)")
 	command.Flags().IntVarP(&limit, "limit", "l", 0, "Limit the number of events to output")
 	command.Flags().BoolVarP(&countOnly, "count", "c", false, "Count the number of events")
+	addContextFlags(command)
 	command.MarkPersistentFlagRequired("sigma")
 
 	return command
 }
 
 func executeAnalysis(_ *cobra.Command, _ []string, sigmaFilePath string, outputFormat string, limit int, countOnly bool) error {
-	// Parse the CSV file & return events
-	events := parser.ParsePurviewCSV(csvFile)
+	// Validate the time window & context before any files are read
+	window, err := timerange.NewWindow(fromTime, toTime)
+	if err != nil {
+		return err
+	}
+	context, err := contextOptions(limit)
+	if err != nil {
+		return err
+	}
+
+	// Open the input files
+	stream, report, err := openStream()
+	if err != nil {
+		return err
+	}
+
+	events, err := applyWindow(stream, window)
+	if err != nil {
+		return closeReport(report, err)
+	}
 
 	// Analyse the events using Sigma rules
 	filteredEvents := analysis.AnalysePurviewCSV(events, sigmaFilePath)
 
+	// The limit applies to the hits rather than the context around them
+	if context.Enabled() {
+		filteredEvents = surrounding.Add(filteredEvents, timerange.Localise(window.Apply(stream.Quiet().Events())), context)
+		limit = 0
+	}
+
 	// Process the results
-	return output.ProcessResults(filteredEvents, output.ResultOptions{
-		Limit:        limit,
-		CountOnly:    countOnly,
-		OutputFormat: outputFormat,
-		OutputFile:   outputFile,
-		IncludeSigma: true,
+	err = output.ProcessResults(filteredEvents, output.ResultOptions{
+		Limit:          limit,
+		CountOnly:      countOnly,
+		OutputFormat:   outputFormat,
+		OutputFile:     outputFile,
+		IncludeSigma:   true,
+		IncludeContext: context.Enabled(),
+		Err:            stream.Err,
 	})
+
+	return closeReport(report, err)
+}
+
+// Define the flags for pulling in the events around each hit, shared by search & analyse
+func addContextFlags(command *cobra.Command) {
+	command.Flags().StringVarP(&contextBefore, "context-before", "B", "", "Include the same user's events before each hit, as a duration (e.g. 30m) or a number of events")
+	command.Flags().StringVarP(&contextAfter, "context-after", "A", "", "Include the same user's events after each hit, as a duration (e.g. 2h) or a number of events")
+	command.Flags().BoolVarP(&contextSameIP, "context-same-ip", "", false, "Only include context events from the hit's ClientIP as well as its UserID")
+}
+
+// Read the --context-before/--context-after flags, with the limit applying to the hits
+func contextOptions(limit int) (surrounding.Options, error) {
+	before, err := surrounding.ParseSpan(contextBefore)
+	if err != nil {
+		return surrounding.Options{}, err
+	}
+	after, err := surrounding.ParseSpan(contextAfter)
+	if err != nil {
+		return surrounding.Options{}, err
+	}
+
+	return surrounding.Options{
+		Before: before,
+		After:  after,
+		SameIP: contextSameIP,
+		Limit:  limit,
+	}, nil
+}
+
+// Open the input files as a single event stream along with the report for malformed rows
+func openStream() (*parser.Stream, *output.ErrorReport, error) {
+	// Checked here rather than marked required, so commands that read no exports don't need them
+	if len(inputFiles) == 0 {
+		return nil, nil, fmt.Errorf("required flag \"file\" not set, give the exports to read with -f")
+	}
+
+	// Resolve the input files
+	paths, err := parser.ExpandPaths(inputFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report, err := output.NewErrorReport(errorReport)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stream := parser.NewStream(paths, parser.Options{
+		Strict: strict,
+		Report: report.Add,
+		Warn:   report.Warn,
+	})
+
+	return stream, report, nil
+}
+
+// Restrict the events to the --from/--to window before any other work & show them in --timezone
+// Times relative to the newest event, in the window or the query, need the exports to be read once beforehand
+func applyWindow(stream *parser.Stream, window *timerange.Window, filters ...*search.Filter) (iter.Seq[models.PurviewEvent], error) {
+	now := time.Now()
+	latest := time.Time{}
+
+	needsLatest := window.NeedsLatest()
+	for _, filter := range filters {
+		needsLatest = needsLatest || filter.NeedsLatest()
+	}
+
+	if needsLatest {
+		var err error
+		latest, err = stream.Latest()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, filter := range filters {
+		filter.SetLatest(latest)
+	}
+	window.Resolve(latest, now)
+
+	return timerange.Localise(window.Apply(stream.Events())), nil
+}
+
+// Close the malformed row report, keeping the first error
+func closeReport(report *output.ErrorReport, err error) error {
+	if closeErr := report.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
 }
@@ -1,5 +1,11 @@
 package models
 
+// Log sources an event can be read from, matching the Sigma logsource service
+const (
+	LogSourcePurview = "purview"
+	LogSourceSignIn  = "signinlogs"
+)
+
 // EmailItem represents email metadata in Purview logs
 type EmailItem struct {
 	ID                string `json:"id"`
@@ -24,10 +30,14 @@ type PurviewEvent struct {
 	Date                 string         `json:"date"`
 	Time                 string         `json:"time"`
 	Timestamp            string         `json:"timestamp"`
+	TimestampUTC         string         `json:"timestamp_utc"` // Timestamp before --timezone is applied
 	SigmaRuleTitle       string         `json:"sigma_rule_title"`
 	SigmaRuleDescription string         `json:"sigma_rule_description"`
 	SigmaRuleSeverity    string         `json:"sigma_rule_severity"`
 	SigmaRuleTags        []string       `json:"sigma_rule_tags"`
+	ContextOf            string         `json:"context_of"`       // RecordID of the hit this event surrounds, set by --context-before/--context-after
+	MatchedFields        []string       `json:"matched_fields"`   // Fields a keyword search found its keywords in
+	MatchedKeywords      []string       `json:"matched_keywords"` // Keywords a keyword search found
 	UserID               string         `json:"user_id"`
 	Organisation         string         `json:"organisation"`
 	EventSource          string         `json:"event_source"`
@@ -43,9 +53,17 @@ type PurviewEvent struct {
 	Folders              string         `json:"folders"`
 	Folder               string         `json:"folder"`
 	DestinationFolder    string         `json:"destination_folder"`
+	ResultStatus         string         `json:"result_status"`
+	ConditionalAccess    string         `json:"conditional_access"`
+	MFAResult            string         `json:"mfa_result"`
+	Location             string         `json:"location"`
+	CorrelationID        string         `json:"correlation_id"`
+	SessionID            string         `json:"session_id"`
+	LogSource            string         `json:"log_source"`
 	SourceFile           string         `json:"source_file"`
 	Emails               []EmailItem    `json:"emails"`
 	Files                []FileItem     `json:"files"`
+	Properties           map[string]any `json:"properties"` // Name/Value arrays keyed by path & name, e.g. Parameters.ForwardTo
 	RawData              map[string]any `json:"raw_data"`   // Everything from the CSV row
 	AuditData            map[string]any `json:"audit_data"` // Parsed from JSON in AuditData column
 	Flattened            map[string]any `json:"flattened"`  // Combined map for Sigma matching
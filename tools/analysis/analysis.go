@@ -4,6 +4,7 @@ import (
 	// Standard library dependencies
 	"context"
 	"io/fs"
+	"iter"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,7 +18,9 @@ import (
 	"github.com/bradleyjkemp/sigma-go/evaluator"
 )
 
-func AnalysePurviewCSV(events []models.PurviewEvent, sigmaFilePath string) []models.PurviewEvent {
+// Matches events against every Sigma rule found under sigmaFilePath
+// Events are read lazily and each match is yielded as a copy tagged with the rule details
+func AnalysePurviewCSV(events iter.Seq[models.PurviewEvent], sigmaFilePath string) iter.Seq[models.PurviewEvent] {
 	var rules []sigma.Rule
 	yamlFilePaths := getYAMLFiles(sigmaFilePath)
 
@@ -34,30 +37,57 @@ func AnalysePurviewCSV(events []models.PurviewEvent, sigmaFilePath string) []mod
 	}
 	logger.Debugf("Loaded %d Sigma rules from %s", len(rules), sigmaFilePath)
 
-	var filteredEvents []models.PurviewEvent
-	ctx := context.Background()
-
-	for _, rule := range rules {
-		eval := evaluator.ForRule(rule)
-		for _, event := range events {
-			result, _ := eval.Matches(ctx, event.Flattened)
-
-			if result.Match {
-				logger.Debugf("Event %s matched Sigma rule: %s", event.RecordID, rule.Title)
-				event.SigmaRuleTitle = rule.Title
-				event.SigmaRuleDescription = rule.Description
-				event.SigmaRuleSeverity = rule.Level
-				event.SigmaRuleTags = rule.Tags
+	// Build the evaluators once so each event is only read a single time
+	evaluators := make([]*evaluator.RuleEvaluator, len(rules))
+	for index, rule := range rules {
+		evaluators[index] = evaluator.ForRule(rule)
+	}
 
-				filteredEvents = append(filteredEvents, event)
+	return func(yield func(models.PurviewEvent) bool) {
+		ctx := context.Background()
+
+		for event := range events {
+			for index, eval := range evaluators {
+				if !appliesTo(rules[index], event) {
+					continue
+				}
+
+				result, _ := eval.Matches(ctx, event.Flattened)
+
+				if result.Match {
+					rule := rules[index]
+					logger.Debugf("Event %s matched Sigma rule: %s", event.RecordID, rule.Title)
+					match := event
+					match.SigmaRuleTitle = rule.Title
+					match.SigmaRuleDescription = rule.Description
+					match.SigmaRuleSeverity = rule.Level
+					match.SigmaRuleTags = rule.Tags
+
+					if !yield(match) {
+						return
+					}
+				}
 			}
 		}
 	}
-
-	return filteredEvents
 }
 
 // Helpers
+// Check whether a rule's logsource service matches the kind of log the event came from
+// Sign-in log rules only run against sign-in events & other services only against audit events
+func appliesTo(rule sigma.Rule, event models.PurviewEvent) bool {
+	service := strings.ToLower(rule.Logsource.Service)
+	if service == "" {
+		return true
+	}
+
+	if event.LogSource == models.LogSourceSignIn {
+		return service == models.LogSourceSignIn
+	}
+
+	return service != models.LogSourceSignIn
+}
+
 // Get all YAML files from a path
 func getYAMLFiles(root string) []string {
 	var files []string
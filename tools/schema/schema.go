@@ -0,0 +1,219 @@
+package schema
+
+import (
+	// Standard library dependencies
+	"cmp"
+	"fmt"
+	"iter"
+	"reflect"
+	"slices"
+	"strings"
+
+	// Internal dependencies
+	"CloudCutter/models"
+)
+
+// Number of distinct sample values kept per field
+const sampleLimit = 3
+
+// Longest sample value kept before it is truncated
+const sampleLength = 60
+
+// Event fields that hold the raw maps, whose contents are listed key by key instead
+var mapFields = map[string]bool{
+	"RawData":    true,
+	"AuditData":  true,
+	"Flattened":  true,
+	"Properties": true,
+}
+
+// Field describes a queryable field as it was observed in the events
+type Field struct {
+	Name      string   // Field name as written in a query, e.g. AppAccessContext.AADSessionId
+	Count     int      // Number of events the field is present in
+	Types     []string // Value types seen: string, number, bool, array, object or null
+	Workloads []string // M365Service of the events the field is present in
+	Samples   []string // Up to sampleLimit distinct example values
+}
+
+// observedField accumulates a field's details while the events are read
+type observedField struct {
+	field     Field
+	types     map[string]bool
+	workloads map[string]bool
+}
+
+// Observe scans every event & returns the fields actually present, sorted by name, with the number of events read
+// Array elements are merged under the array's name (Folders.Path rather than Folders.0.Path),
+// which is how queries match any element of an array
+func Observe(events iter.Seq[models.PurviewEvent]) ([]Field, int) {
+	observed := make(map[string]*observedField)
+
+	eventCount := 0
+	for event := range events {
+		eventCount++
+		seen := make(map[string]bool)
+		record := func(name string, value any) {
+			key := strings.ToLower(name)
+			field, ok := observed[key]
+			if !ok {
+				field = &observedField{
+					field:     Field{Name: name},
+					types:     make(map[string]bool),
+					workloads: make(map[string]bool),
+				}
+				observed[key] = field
+			}
+
+			// Count each field once per event, however many array elements hold it
+			if !seen[key] {
+				seen[key] = true
+				field.field.Count++
+				if event.M365Service != "" {
+					field.workloads[event.M365Service] = true
+				}
+			}
+
+			field.types[typeName(value)] = true
+			if sample, ok := sampleValue(value); ok && len(field.field.Samples) < sampleLimit && !slices.Contains(field.field.Samples, sample) {
+				field.field.Samples = append(field.field.Samples, sample)
+			}
+		}
+
+		// Model fields with a value
+		eventValue := reflect.ValueOf(event)
+		for index := 0; index < eventValue.NumField(); index++ {
+			name := eventValue.Type().Field(index).Name
+			if mapFields[name] || eventValue.Field(index).IsZero() {
+				continue
+			}
+			walk(name, eventValue.Field(index).Interface(), record)
+		}
+
+		// AuditData first, so fields in both keep the case AuditData writes them in
+		for key, value := range event.AuditData {
+			walk(key, value, record)
+		}
+
+		// Columns of the row itself, such as RecordType, other than the AuditData already walked
+		for key, value := range event.RawData {
+			if strings.EqualFold(key, "auditdata") && len(event.AuditData) > 0 {
+				continue
+			}
+			walk(key, value, record)
+		}
+
+		for key, value := range event.Properties {
+			record(key, value)
+		}
+	}
+
+	fields := make([]Field, 0, len(observed))
+	for _, observedField := range observed {
+		field := observedField.field
+		field.Types = sortedKeys(observedField.types)
+		field.Workloads = sortedKeys(observedField.workloads)
+		fields = append(fields, field)
+	}
+	slices.SortFunc(fields, func(a, b Field) int {
+		return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+
+	return fields, eventCount
+}
+
+// Records a value & every value nested inside it under its dotted path
+func walk(path string, value any, record func(string, any)) {
+	record(path, value)
+
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, child := range typed {
+			walk(path+"."+key, child, record)
+		}
+	case []any:
+		// Name/Value arrays are listed through the event's Properties instead
+		for _, element := range typed {
+			if entry, ok := element.(map[string]any); ok {
+				if _, isNameValue := entry["Name"]; isNameValue {
+					return
+				}
+			}
+		}
+		for _, element := range typed {
+			if object, ok := element.(map[string]any); ok {
+				for key, child := range object {
+					walk(path+"."+key, child, record)
+				}
+			}
+		}
+	default:
+		// Model slices such as Emails & Files
+		reflected := reflect.ValueOf(value)
+		if reflected.Kind() != reflect.Slice {
+			return
+		}
+		for index := 0; index < reflected.Len(); index++ {
+			element := reflect.Indirect(reflected.Index(index))
+			if element.Kind() != reflect.Struct {
+				continue
+			}
+			for fieldIndex := 0; fieldIndex < element.NumField(); fieldIndex++ {
+				if !element.Field(fieldIndex).IsZero() {
+					record(path+"."+element.Type().Field(fieldIndex).Name, element.Field(fieldIndex).Interface())
+				}
+			}
+		}
+	}
+}
+
+// Names the type of a value as it would appear in JSON
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64, float32, int, int64, int32:
+		return "number"
+	case map[string]any:
+		return "object"
+	}
+
+	if kind := reflect.ValueOf(value).Kind(); kind == reflect.Slice || kind == reflect.Array {
+		return "array"
+	}
+
+	return "string"
+}
+
+// Formats a scalar value as a sample, objects & arrays have no useful sample
+func sampleValue(value any) (string, bool) {
+	switch typeName(value) {
+	case "object", "array", "null":
+		return "", false
+	}
+
+	sample := fmt.Sprint(value)
+	if sample == "" {
+		return "", false
+	}
+	if runes := []rune(sample); len(runes) > sampleLength {
+		sample = string(runes[:sampleLength]) + "..."
+	}
+
+	return sample, true
+}
+
+// Returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,339 @@
+package search
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/timerange"
+	"CloudCutter/models"
+)
+
+// expression is a node of a parsed query
+// Conditions evaluate to a bool, values to whatever they resolve to for the event
+type expression interface {
+	evaluate(event models.PurviewEvent) any
+	String() string
+}
+
+// logicalNode joins two conditions with AND or OR
+type logicalNode struct {
+	operator string
+	left     expression
+	right    expression
+}
+
+func (node *logicalNode) evaluate(event models.PurviewEvent) any {
+	left := isTrue(node.left.evaluate(event))
+
+	// Short-circuit so the right-hand side is only resolved when it matters
+	if node.operator == "AND" {
+		return left && isTrue(node.right.evaluate(event))
+	}
+
+	return left || isTrue(node.right.evaluate(event))
+}
+
+func (node *logicalNode) String() string {
+	return fmt.Sprintf("(%s %s %s)", node.left, node.operator, node.right)
+}
+
+// notNode negates a condition
+// A negated comparison against an array is true only when no element matches
+type notNode struct {
+	operand expression
+	left    expression // Value tested by a negated operator such as NOT LIKE, which is false when it is missing
+}
+
+func (node *notNode) evaluate(event models.PurviewEvent) any {
+	if node.left != nil && node.left.evaluate(event) == nil {
+		return false
+	}
+
+	return !isTrue(node.operand.evaluate(event))
+}
+
+func (node *notNode) String() string {
+	return fmt.Sprintf("NOT %s", node.operand)
+}
+
+// existsNode checks whether a field holds a value in the event
+// Written as EXISTS Field or Field IS NOT NULL, with IS NULL being its negation
+type existsNode struct {
+	field    *fieldNode
+	operator string
+}
+
+func (node *existsNode) evaluate(event models.PurviewEvent) any {
+	_, found := lookupField(node.field.parts, event)
+	return found
+}
+
+func (node *existsNode) String() string {
+	if node.operator == "IS NOT NULL" {
+		return fmt.Sprintf("%s IS NOT NULL", node.field)
+	}
+
+	return fmt.Sprintf("EXISTS %s", node.field)
+}
+
+// comparisonNode compares two values with ==, !=, >, >=, <, <= or LIKE
+type comparisonNode struct {
+	operator string
+	left     expression
+	right    expression
+}
+
+func (node *comparisonNode) evaluate(event models.PurviewEvent) any {
+	left := node.left.evaluate(event)
+	right := node.right.evaluate(event)
+
+	return compute(left, node.operator, right)
+}
+
+func (node *comparisonNode) String() string {
+	return fmt.Sprintf("%s %s %s", node.left, node.operator, node.right)
+}
+
+// matchNode checks a value against a compiled regular expression
+type matchNode struct {
+	left    expression
+	pattern *regexp.Regexp
+}
+
+func (node *matchNode) evaluate(event models.PurviewEvent) any {
+	return anyElement(node.left.evaluate(event), func(value any) bool {
+		return value != nil && node.pattern.MatchString(fmt.Sprint(value))
+	})
+}
+
+func (node *matchNode) String() string {
+	return fmt.Sprintf("%s MATCHES %s", node.left, strconv.Quote(node.pattern.String()))
+}
+
+// inNode checks whether a value equals any of a list of values
+type inNode struct {
+	left   expression
+	values []expression
+}
+
+func (node *inNode) evaluate(event models.PurviewEvent) any {
+	left := node.left.evaluate(event)
+	for _, value := range node.values {
+		if compute(left, "==", value.evaluate(event)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (node *inNode) String() string {
+	values := make([]string, len(node.values))
+	for index, value := range node.values {
+		values[index] = value.String()
+	}
+
+	return fmt.Sprintf("%s IN (%s)", node.left, strings.Join(values, ", "))
+}
+
+// betweenNode checks whether a value lies within an inclusive range
+// A date on its own as the upper bound includes the whole of that day, as with --to
+type betweenNode struct {
+	left expression
+	low  expression
+	high expression
+}
+
+func (node *betweenNode) evaluate(event models.PurviewEvent) any {
+	low := node.low.evaluate(event)
+	high := node.high.evaluate(event)
+	if text, ok := high.(string); ok {
+		if end, ok := timerange.DayEnd(text); ok {
+			high = end.Format(time.RFC3339Nano)
+		}
+	}
+
+	// Both bounds must hold for the same element of an array
+	return anyElement(node.left.evaluate(event), func(value any) bool {
+		return compute(value, ">=", low) && compute(value, "<=", high)
+	})
+}
+
+func (node *betweenNode) String() string {
+	return fmt.Sprintf("%s BETWEEN %s AND %s", node.left, node.low, node.high)
+}
+
+// cidrNode checks whether a value is an IP address within one of a set of networks
+type cidrNode struct {
+	left     expression
+	prefixes []netip.Prefix
+}
+
+func (node *cidrNode) evaluate(event models.PurviewEvent) any {
+	return anyElement(node.left.evaluate(event), func(value any) bool {
+		return value != nil && inNetworks(value, node.prefixes)
+	})
+}
+
+func (node *cidrNode) String() string {
+	prefixes := make([]string, len(node.prefixes))
+	for index, prefix := range node.prefixes {
+		prefixes[index] = prefix.String()
+	}
+
+	return fmt.Sprintf("%s IN_CIDR (%s)", node.left, strings.Join(prefixes, ", "))
+}
+
+// callNode calls a built-in function with the resolved values of its arguments
+type callNode struct {
+	name      string
+	function  function
+	arguments []expression
+}
+
+func (node *callNode) evaluate(event models.PurviewEvent) any {
+	arguments := make([]any, len(node.arguments))
+	for index, argument := range node.arguments {
+		arguments[index] = argument.evaluate(event)
+	}
+
+	return node.function.call(arguments)
+}
+
+func (node *callNode) String() string {
+	arguments := make([]string, len(node.arguments))
+	for index, argument := range node.arguments {
+		arguments[index] = argument.String()
+	}
+
+	return fmt.Sprintf("%s(%s)", node.name, strings.Join(arguments, ", "))
+}
+
+// keywordNode is a word or string on its own, which matches when it appears in any value of the event
+type keywordNode struct {
+	text     string
+	keywords *Keywords
+}
+
+func (node *keywordNode) evaluate(event models.PurviewEvent) any {
+	return node.keywords.Match(event)
+}
+
+func (node *keywordNode) String() string {
+	return fmt.Sprintf("%q", node.text)
+}
+
+// arithmeticNode adds, subtracts, multiplies or divides two values
+type arithmeticNode struct {
+	operator string
+	left     expression
+	right    expression
+}
+
+func (node *arithmeticNode) evaluate(event models.PurviewEvent) any {
+	return calculate(node.left.evaluate(event), node.operator, node.right.evaluate(event))
+}
+
+func (node *arithmeticNode) String() string {
+	return fmt.Sprintf("(%s %s %s)", node.left, node.operator, node.right)
+}
+
+// fieldNode is a bare word: a field path such as Emails.Subject, or a literal value if no such field exists
+type fieldNode struct {
+	path     string
+	parts    []string
+	fallback bool // Whether the word is read as a literal when no field matches
+}
+
+func (node *fieldNode) evaluate(event models.PurviewEvent) any {
+	if value := resolveField(node.parts, event); value != nil {
+		return value
+	}
+
+	// Fallback for words that aren't fields: treat as literal
+	if node.fallback {
+		return node.path
+	}
+
+	return nil
+}
+
+func (node *fieldNode) String() string {
+	return node.path
+}
+
+// literalNode is a quoted string, or bare words merged into one value
+type literalNode struct {
+	value string
+}
+
+func (node *literalNode) evaluate(models.PurviewEvent) any {
+	return node.value
+}
+
+func (node *literalNode) String() string {
+	return strconv.Quote(node.value)
+}
+
+// clock holds the times relative expressions are resolved against
+type clock struct {
+	latest      time.Time // Newest event in the loaded exports
+	now         time.Time // When the query was parsed
+	needsLatest bool      // Whether any expression is relative to the newest event
+}
+
+// timeNode is a relative time such as -48h or now-2h
+type timeNode struct {
+	expression timerange.Expression
+	clock      *clock
+}
+
+func (node *timeNode) evaluate(models.PurviewEvent) any {
+	return node.expression.Resolve(node.clock.latest, node.clock.now).UTC().Format(time.RFC3339)
+}
+
+func (node *timeNode) String() string {
+	return node.expression.String()
+}
+
+// Checks whether a value, or any element of it if it is an array, passes a test
+func anyElement(value any, test func(any) bool) bool {
+	if value != nil && reflect.TypeOf(value).Kind() == reflect.Slice {
+		elements := reflect.ValueOf(value)
+		for index := 0; index < elements.Len(); index++ {
+			if anyElement(elements.Index(index).Interface(), test) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return test(value)
+}
+
+// Applies transform to each element of an array, or to the value itself otherwise
+func eachElement(value any, transform func(any) any) any {
+	if value != nil && reflect.TypeOf(value).Kind() == reflect.Slice {
+		elements := reflect.ValueOf(value)
+		results := make([]any, 0, elements.Len())
+		for index := 0; index < elements.Len(); index++ {
+			results = append(results, eachElement(elements.Index(index).Interface(), transform))
+		}
+		return results
+	}
+
+	return transform(value)
+}
+
+// Checks whether a condition evaluated to true
+func isTrue(value any) bool {
+	result, isBool := value.(bool)
+	return isBool && result
+}
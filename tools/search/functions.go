@@ -0,0 +1,127 @@
+package search
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// function is a built-in query function
+type function struct {
+	arguments int                       // Number of arguments it takes
+	call      func(arguments []any) any // Computes the result from the resolved arguments
+}
+
+// Built-in functions, named in lower case
+var functions = map[string]function{
+	"is_private": {arguments: 1, call: func(arguments []any) any {
+		return anyElement(arguments[0], isPrivateIP)
+	}},
+	"is_loopback": {arguments: 1, call: func(arguments []any) any {
+		return anyElement(arguments[0], isLoopbackIP)
+	}},
+	"is_public": {arguments: 1, call: func(arguments []any) any {
+		return anyElement(arguments[0], isPublicIP)
+	}},
+	"len": {arguments: 1, call: func(arguments []any) any {
+		return length(arguments[0])
+	}},
+	"lower": {arguments: 1, call: func(arguments []any) any {
+		return eachElement(arguments[0], func(value any) any {
+			return mapText(value, strings.ToLower)
+		})
+	}},
+	"upper": {arguments: 1, call: func(arguments []any) any {
+		return eachElement(arguments[0], func(value any) any {
+			return mapText(value, strings.ToUpper)
+		})
+	}},
+	"startswith": {arguments: 2, call: func(arguments []any) any {
+		return anyText(arguments[0], arguments[1], strings.HasPrefix)
+	}},
+	"endswith": {arguments: 2, call: func(arguments []any) any {
+		return anyText(arguments[0], arguments[1], strings.HasSuffix)
+	}},
+	"contains": {arguments: 2, call: func(arguments []any) any {
+		return anyText(arguments[0], arguments[1], strings.Contains)
+	}},
+	"split": {arguments: 2, call: func(arguments []any) any {
+		separator := argumentText(arguments[1])
+		parts := []any{}
+		anyElement(arguments[0], func(value any) bool {
+			if value != nil {
+				for _, part := range strings.Split(fmt.Sprint(value), separator) {
+					parts = append(parts, part)
+				}
+			}
+			return false
+		})
+		return parts
+	}},
+	"domain": {arguments: 1, call: func(arguments []any) any {
+		return eachElement(arguments[0], func(value any) any {
+			return mapText(value, emailDomain)
+		})
+	}},
+}
+
+// Counts the elements of an array or object, or the characters of any other value
+// A missing value has no length, so it doesn't compare equal to 0
+func length(value any) any {
+	if value == nil {
+		return nil
+	}
+
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return reflect.ValueOf(value).Len()
+	}
+
+	return utf8.RuneCountInString(fmt.Sprint(value))
+}
+
+// Applies a text transformation to a value, leaving missing values missing
+func mapText(value any, transform func(string) string) any {
+	if value == nil {
+		return nil
+	}
+
+	return transform(fmt.Sprint(value))
+}
+
+// Checks whether any element of a value passes a case-insensitive text test against an argument
+func anyText(value any, argument any, test func(text string, argument string) bool) bool {
+	argumentValue := strings.ToLower(argumentText(argument))
+
+	return anyElement(value, func(element any) bool {
+		return element != nil && test(strings.ToLower(fmt.Sprint(element)), argumentValue)
+	})
+}
+
+// Reads an argument that is used as text, such as a prefix or separator
+func argumentText(argument any) string {
+	if argument == nil {
+		return ""
+	}
+
+	return fmt.Sprint(argument)
+}
+
+// Returns the lower case domain of an email address or UPN, or nothing if it isn't one
+func emailDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSpace(address[at+1:]))
+}
+
+// Returns the names of the built-in functions in order
+func functionNames() []string {
+	return slices.Sorted(maps.Keys(functions))
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Parses an IP address as Purview records it: IPv4, IPv6, IPv4:port, [IPv6] or [IPv6]:port
+// IPv4-mapped IPv6 addresses (::ffff:203.0.113.7) are returned as plain IPv4
+func parseIP(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap(), true
+	}
+
+	// Address with a port, e.g. 203.0.113.7:443 or [2001:db8::1]:8080
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	// Bracketed IPv6 without a port
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		if addr, err := netip.ParseAddr(value[1 : len(value)-1]); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+
+	return netip.Addr{}, false
+}
+
+// Parses a CIDR network such as 203.0.113.0/24 or 2001:db8::/32
+// A single address is treated as a network of just that address
+func parsePrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+
+	if !strings.Contains(value, "/") {
+		addr, ok := parseIP(value)
+		if !ok {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR network '%s'", value)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR network '%s'", value)
+	}
+
+	// 203.0.113.7/24 means the network it belongs to
+	return prefix.Masked(), nil
+}
+
+// Checks whether a value is an IP address in one of the networks
+func inNetworks(value any, prefixes []netip.Prefix) bool {
+	addr, ok := parseIP(fmt.Sprint(value))
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks whether a value is a private address: RFC 1918, RFC 4193 unique local or link-local
+func isPrivateIP(value any) bool {
+	addr, ok := parseIP(fmt.Sprint(value))
+	return ok && (addr.IsPrivate() || addr.IsLinkLocalUnicast())
+}
+
+// Checks whether a value is a loopback address
+func isLoopbackIP(value any) bool {
+	addr, ok := parseIP(fmt.Sprint(value))
+	return ok && addr.IsLoopback()
+}
+
+// Checks whether a value is a publicly routable address, i.e. a global unicast address that isn't private
+func isPublicIP(value any) bool {
+	addr, ok := parseIP(fmt.Sprint(value))
+	return ok && addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
@@ -0,0 +1,154 @@
+package search
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	// Internal dependencies
+	"CloudCutter/models"
+
+	// External dependencies
+	ahocorasick "github.com/BobuSumisu/aho-corasick"
+)
+
+// Keywords finds text anywhere in an event's values, whichever field holds it
+// Every keyword is looked for in a single pass over each value, however many there are
+type Keywords struct {
+	keywords []string // Lower case, in the order the trie numbers them
+	trie     *ahocorasick.Trie
+}
+
+// NewKeywords builds a matcher for the keywords, which are matched case-insensitively
+func NewKeywords(keywords []string) *Keywords {
+	var lowered []string
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword != "" && !slices.Contains(lowered, keyword) {
+			lowered = append(lowered, keyword)
+		}
+	}
+
+	return &Keywords{
+		keywords: lowered,
+		trie:     ahocorasick.NewTrieBuilder().AddStrings(lowered).Build(),
+	}
+}
+
+// Match checks whether any keyword appears in any value of the event
+func (keywords *Keywords) Match(event models.PurviewEvent) bool {
+	matched := false
+	walkValues(event, func(_ string, text string) bool {
+		matched = keywords.contains(text, nil)
+		return !matched
+	})
+
+	return matched
+}
+
+// Find returns the fields whose values contain a keyword & the keywords that were found
+func (keywords *Keywords) Find(event models.PurviewEvent) ([]string, []string) {
+	var fields []string
+	found := make(map[int64]bool)
+
+	walkValues(event, func(path string, text string) bool {
+		if keywords.contains(text, found) && !slices.ContainsFunc(fields, func(field string) bool {
+			return strings.EqualFold(field, path)
+		}) {
+			fields = append(fields, path)
+		}
+		return true
+	})
+
+	var foundKeywords []string
+	for _, pattern := range slices.Sorted(maps.Keys(found)) {
+		foundKeywords = append(foundKeywords, keywords.keywords[pattern])
+	}
+
+	return fields, foundKeywords
+}
+
+// Checks whether the text holds any keyword, noting every keyword in it when found isn't nil
+func (keywords *Keywords) contains(text string, found map[int64]bool) bool {
+	matched := false
+	keywords.trie.Walk([]byte(strings.ToLower(text)), func(_ int64, _ int64, pattern int64) bool {
+		matched = true
+		if found == nil {
+			return false
+		}
+		found[pattern] = true
+		return true
+	})
+
+	return matched
+}
+
+// Calls visit with the dotted path & text of every value in the row & its nested AuditData, until it returns false
+// The AuditData column itself is skipped once parsed, so matches are reported against the key that holds them
+func walkValues(event models.PurviewEvent, visit func(path string, text string) bool) {
+	for _, key := range slices.Sorted(maps.Keys(event.RawData)) {
+		if strings.EqualFold(key, "auditdata") && len(event.AuditData) > 0 {
+			continue
+		}
+		if !walkValue(key, event.RawData[key], visit) {
+			return
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(event.AuditData)) {
+		if !walkValue(key, event.AuditData[key], visit) {
+			return
+		}
+	}
+}
+
+// Visits a value & every value nested inside it, with array elements addressed by index as in Flattened
+// & the values of Name/Value arrays by their name as in Properties
+func walkValue(path string, value any, visit func(path string, text string) bool) bool {
+	switch typed := value.(type) {
+	case nil:
+		return true
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(typed)) {
+			if !walkValue(path+"."+key, typed[key], visit) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		for index, element := range typed {
+			elementPath := path + "." + strconv.Itoa(index)
+
+			// Name/Value entries are reported under their keyed property, e.g. Parameters.ForwardTo
+			if entry, ok := element.(map[string]any); ok {
+				if name, ok := entry["Name"].(string); ok && name != "" {
+					for _, key := range slices.Sorted(maps.Keys(entry)) {
+						keyPath := elementPath + "." + key
+						switch key {
+						case "Name":
+							continue
+						case "Value":
+							keyPath = path + "." + name
+						case "NewValue", "OldValue":
+							keyPath = path + "." + name + "." + key
+						}
+						if !walkValue(keyPath, entry[key], visit) {
+							return false
+						}
+					}
+					continue
+				}
+			}
+
+			if !walkValue(elementPath, element, visit) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return visit(path, fmt.Sprint(value))
+}
@@ -0,0 +1,142 @@
+package search
+
+import (
+	// Standard library dependencies
+	"slices"
+	"strings"
+)
+
+// tokenKind identifies the kind of a query token
+type tokenKind int
+
+const (
+	tokenEnd        tokenKind = iota // End of the query
+	tokenWord                        // Field name, bare value or keyword
+	tokenString                      // Quoted string
+	tokenOperator                    // Comparison operator
+	tokenLeftParen                   // (
+	tokenRightParen                  // )
+	tokenComma                       // , between IN values
+)
+
+// token is a single lexical unit of a query along with where it was found
+type token struct {
+	kind   tokenKind
+	text   string // Text as written in the query
+	value  string // Unquoted value of strings, the text otherwise
+	offset int    // Byte offset of the token in the query
+	filled bool   // Value was filled in from a parameter, so it is always a literal
+}
+
+// Comparison operators, longest first so >= isn't read as >
+var comparisonOperators = []string{"==", "!=", ">=", "<=", "=~", ">", "<"}
+
+// Arithmetic operators, highest precedence first
+// They are only operators when written as words on their own, as - & / also appear within values such as -48h
+var arithmeticOperators = [][]string{{"*", "/"}, {"+", "-"}}
+
+// Describes a token for error messages
+func (token token) describe() string {
+	switch token.kind {
+	case tokenEnd:
+		return "end of query"
+	case tokenString:
+		return token.text
+	}
+
+	return "'" + token.text + "'"
+}
+
+// Checks whether a token is the given keyword, whatever its case
+func (token token) is(keyword string) bool {
+	return token.kind == tokenWord && strings.EqualFold(token.text, keyword)
+}
+
+// Checks whether a token is one of the given arithmetic operators
+func (token token) isArithmetic(operators []string) bool {
+	return token.kind == tokenWord && slices.Contains(operators, token.text)
+}
+
+// Splits a query into tokens
+// Strings may be quoted with ' or " and contain the other quote, or their own quote escaped with \
+func lex(query string) ([]token, error) {
+	var tokens []token
+
+	for offset := 0; offset < len(query); {
+		character := query[offset]
+
+		switch {
+		case character == ' ' || character == '\t' || character == '\r' || character == '\n':
+			offset++
+
+		case character == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, text: "(", value: "(", offset: offset})
+			offset++
+
+		case character == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, text: ")", value: ")", offset: offset})
+			offset++
+
+		case character == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", value: ",", offset: offset})
+			offset++
+
+		case character == '\'' || character == '"':
+			end, value, ok := scanString(query, offset)
+			if !ok {
+				return nil, syntaxError(query, offset, "unterminated string, expected a closing %c", character)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: query[offset:end], value: value, offset: offset})
+			offset = end
+
+		case strings.ContainsRune("=!<>", rune(character)):
+			operator := ""
+			for _, candidate := range comparisonOperators {
+				if strings.HasPrefix(query[offset:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				if character == '=' {
+					return nil, syntaxError(query, offset, "unexpected '=', did you mean '=='?")
+				}
+				return nil, syntaxError(query, offset, "unexpected '%c'", character)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, value: operator, offset: offset})
+			offset += len(operator)
+
+		default:
+			end := offset
+			for end < len(query) && !strings.ContainsRune(" \t\r\n(),'\"=!<>", rune(query[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: query[offset:end], value: query[offset:end], offset: offset})
+			offset = end
+		}
+	}
+
+	return append(tokens, token{kind: tokenEnd, offset: len(query)}), nil
+}
+
+// Reads a quoted string starting at offset
+// Returns the offset just past the closing quote & the unquoted value
+func scanString(query string, offset int) (int, string, bool) {
+	quote := query[offset]
+	var value strings.Builder
+
+	for index := offset + 1; index < len(query); index++ {
+		switch {
+		case query[index] == '\\' && index+1 < len(query) && query[index+1] == quote:
+			// Escaped quote
+			value.WriteByte(quote)
+			index++
+		case query[index] == quote:
+			return index + 1, value.String(), true
+		default:
+			value.WriteByte(query[index])
+		}
+	}
+
+	return 0, "", false
+}
@@ -0,0 +1,602 @@
+package search
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	// Internal dependencies
+	"CloudCutter/internal/timerange"
+)
+
+// SyntaxError describes why a query couldn't be parsed & where
+type SyntaxError struct {
+	Query   string // Query as written
+	Column  int    // 1-based column of the problem
+	Message string // What was expected or found
+}
+
+func (syntaxError *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid query at column %d: %s\n  %s\n  %s^",
+		syntaxError.Column, syntaxError.Message, syntaxError.Query, strings.Repeat(" ", syntaxError.Column-1))
+}
+
+// Builds a syntax error for the given byte offset in the query
+func syntaxError(query string, offset int, format string, args ...any) *SyntaxError {
+	return &SyntaxError{
+		Query:   query,
+		Column:  utf8.RuneCountInString(query[:offset]) + 1,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Placeholders for parameters, e.g. ${user}
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Bare words that are read as numbers rather than field names
+var numberPattern = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// parser builds an expression tree from the tokens of a query by recursive descent
+//
+// Grammar, from the loosest binding to the tightest:
+//
+//	query      := or END
+//	or         := and { OR and }
+//	and        := unary { AND unary }
+//	unary      := NOT unary | EXISTS word | primary
+//	primary    := '(' or ')' | comparison
+//	comparison := call
+//	            | word | string
+//	            | field IS [NOT] NULL
+//	            | operand operator value
+//	            | operand [NOT] LIKE value
+//	            | operand [NOT] MATCHES value | operand =~ value
+//	            | operand [NOT] IN '(' value { ',' value } ')'
+//	            | operand [NOT] BETWEEN value AND value
+//	            | operand [NOT] IN_CIDR (value | '(' value { ',' value } ')')
+//	operand    := product { ('+' | '-') product }
+//	product    := term { ('*' | '/') term }
+//	term       := word | string | call
+//	call       := name '(' [ operand { ',' operand } ] ')'
+//	value      := operand starting with a call or number | word | string | word word...
+type parser struct {
+	query    string
+	tokens   []token
+	position int
+	clock    *clock   // Shared by the relative times in the query
+	keywords []string // Words & strings searched for on their own
+}
+
+// Parses a query into an expression tree, validating it as a whole
+// Also returns the words & strings searched for on their own, so the fields they matched can be reported
+func parse(query string, clock *clock, parameters map[string]string) (expression, []string, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	fillPlaceholders(tokens, parameters)
+
+	if len(tokens) == 1 {
+		return nil, nil, syntaxError(query, 0, "empty query")
+	}
+
+	parser := &parser{query: query, tokens: tokens, clock: clock}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Anything left over means a condition wasn't joined to the rest
+	if next := parser.peek(); next.kind != tokenEnd {
+		if next.kind == tokenRightParen {
+			return nil, nil, parser.errorAt(next, "unexpected ')' with no matching '('")
+		}
+		return nil, nil, parser.errorAt(next, "expected AND, OR or end of query, found %s", next.describe())
+	}
+
+	return root, parser.keywords, nil
+}
+
+// Fills the ${name} placeholders in word & string tokens with their parameter values
+// Only the value changes & the token is marked as filled, so a parameter is always a single literal value,
+// never a field name, keyword, operator or closing quote
+func fillPlaceholders(tokens []token, parameters map[string]string) {
+	if len(parameters) == 0 {
+		return
+	}
+
+	for index, token := range tokens {
+		if token.kind != tokenWord && token.kind != tokenString {
+			continue
+		}
+		tokens[index].value = placeholderPattern.ReplaceAllStringFunc(token.value, func(placeholder string) string {
+			if value, ok := parameters[placeholderPattern.FindStringSubmatch(placeholder)[1]]; ok {
+				tokens[index].filled = true
+				return value
+			}
+			return placeholder
+		})
+	}
+}
+
+// Returns the current token without consuming it
+func (parser *parser) peek() token {
+	return parser.tokens[parser.position]
+}
+
+// Consumes & returns the current token
+func (parser *parser) next() token {
+	current := parser.tokens[parser.position]
+	if current.kind != tokenEnd {
+		parser.position++
+	}
+
+	return current
+}
+
+// Builds a syntax error pointing at a token
+func (parser *parser) errorAt(token token, format string, args ...any) error {
+	return syntaxError(parser.query, token.offset, format, args...)
+}
+
+// or := and { OR and }
+func (parser *parser) parseOr() (expression, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.peek().is("OR") {
+		operator := parser.next()
+		right, err := parser.parseOperand(operator, parser.parseAnd)
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{operator: "OR", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// and := unary { AND unary }
+func (parser *parser) parseAnd() (expression, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.peek().is("AND") {
+		operator := parser.next()
+		right, err := parser.parseOperand(operator, parser.parseUnary)
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{operator: "AND", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// Parses the condition following a logical operator, with a clearer error when there isn't one
+func (parser *parser) parseOperand(operator token, parse func() (expression, error)) (expression, error) {
+	if next := parser.peek(); next.kind == tokenEnd || next.kind == tokenRightParen || next.is("AND") || next.is("OR") {
+		return nil, parser.errorAt(next, "expected a condition after %s, found %s", strings.ToUpper(operator.text), next.describe())
+	}
+
+	return parse()
+}
+
+// unary := NOT unary | EXISTS word | primary
+func (parser *parser) parseUnary() (expression, error) {
+	if parser.peek().is("EXISTS") {
+		operator := parser.next()
+		field := parser.peek()
+		if field.kind != tokenWord || field.is("AND") || field.is("OR") || field.is("NOT") {
+			return nil, parser.errorAt(field, "expected a field name after EXISTS, found %s", field.describe())
+		}
+		parser.next()
+		return &existsNode{field: &fieldNode{path: field.value, parts: strings.Split(field.value, ".")}, operator: operator.text}, nil
+	}
+
+	if !parser.peek().is("NOT") {
+		return parser.parsePrimary()
+	}
+
+	operator := parser.next()
+	operand, err := parser.parseOperand(operator, parser.parseUnary)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notNode{operand: operand}, nil
+}
+
+// primary := '(' or ')' | comparison
+func (parser *parser) parsePrimary() (expression, error) {
+	next := parser.peek()
+
+	switch {
+	case next.kind == tokenLeftParen:
+		open := parser.next()
+		inner, err := parser.parseOperand(open, parser.parseOr)
+		if err != nil {
+			return nil, err
+		}
+		if closing := parser.peek(); closing.kind != tokenRightParen {
+			return nil, parser.errorAt(closing, "expected ')' to close the '(' at column %d, found %s",
+				utf8.RuneCountInString(parser.query[:open.offset])+1, closing.describe())
+		}
+		parser.next()
+		return inner, nil
+
+	case next.is("AND") || next.is("OR"):
+		return nil, parser.errorAt(next, "expected a condition before %s", strings.ToUpper(next.text))
+
+	case next.kind == tokenWord || next.kind == tokenString:
+		return parser.parseComparison()
+	}
+
+	return nil, parser.errorAt(next, "expected a condition, found %s", next.describe())
+}
+
+// comparison := operand operator value | operand [NOT] (LIKE | MATCHES | IN | BETWEEN) ...
+func (parser *parser) parseComparison() (expression, error) {
+	operand := parser.peek()
+	left, err := parser.parseArithmetic(false)
+	if err != nil {
+		return nil, err
+	}
+
+	// A function such as is_private(ClientIP) can be a condition on its own, & a word or string
+	// on its own is searched for in every value, e.g. '203.0.113.7' AND Operation == 'UserLoggedIn'
+	if next := parser.peek(); next.kind == tokenEnd || next.kind == tokenRightParen || next.is("AND") || next.is("OR") {
+		switch typed := left.(type) {
+		case *callNode:
+			return left, nil
+		case *fieldNode, *literalNode:
+			text := valueText(typed)
+			parser.keywords = append(parser.keywords, text)
+			return &keywordNode{text: text, keywords: NewKeywords([]string{text})}, nil
+		}
+	}
+
+	// Field IS [NOT] NULL
+	if parser.peek().is("IS") {
+		return parser.parseIsNull(operand, left)
+	}
+
+	// Keyword operators can be negated in place, e.g. ClientIP NOT LIKE '10.*'
+	negated := parser.peek().is("NOT")
+	if negated {
+		parser.next()
+	}
+
+	var comparison expression
+
+	operator := parser.peek()
+	switch {
+	case operator.is("MATCHES") || operator.text == "=~" && !negated:
+		parser.next()
+		comparison, err = parser.parseMatches(operator, left)
+	case operator.kind == tokenOperator && !negated, operator.is("LIKE"):
+		parser.next()
+		var right expression
+		right, err = parser.parseValue(operator)
+		comparison = &comparisonNode{operator: strings.ToUpper(operator.text), left: left, right: right}
+	case operator.is("IN"):
+		parser.next()
+		comparison, err = parser.parseIn(operator, left)
+	case operator.is("BETWEEN"):
+		parser.next()
+		comparison, err = parser.parseBetween(operator, left)
+	case operator.is("IN_CIDR"):
+		parser.next()
+		comparison, err = parser.parseInCIDR(operator, left)
+	case negated:
+		return nil, parser.errorAt(operator, "expected LIKE, MATCHES, IN, BETWEEN or IN_CIDR after NOT, found %s", operator.describe())
+	default:
+		return nil, parser.errorAt(operator, "expected a comparison operator (==, !=, >, >=, <, <=, =~, LIKE, MATCHES, IN, BETWEEN, IN_CIDR, IS) after %s, found %s",
+			operand.describe(), operator.describe())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if negated {
+		comparison = &notNode{operand: comparison, left: left}
+	}
+
+	return comparison, nil
+}
+
+// Parses the rest of Field IS [NOT] NULL
+func (parser *parser) parseIsNull(operand token, left expression) (expression, error) {
+	field, isField := left.(*fieldNode)
+	if !isField {
+		return nil, parser.errorAt(operand, "expected a field name before IS, found %s", operand.describe())
+	}
+	is := parser.next()
+
+	negated := parser.peek().is("NOT")
+	if negated {
+		parser.next()
+	}
+
+	if null := parser.peek(); !null.is("NULL") {
+		return nil, parser.errorAt(null, "expected NULL after %s, found %s", strings.ToUpper(is.text), null.describe())
+	}
+	parser.next()
+
+	if negated {
+		return &existsNode{field: field, operator: "IS NOT NULL"}, nil
+	}
+
+	return &notNode{operand: &existsNode{field: field, operator: "IS NOT NULL"}}, nil
+}
+
+// Parses & compiles the RE2 pattern of a MATCHES comparison, so it is only compiled once per query
+func (parser *parser) parseMatches(operator token, left expression) (expression, error) {
+	start := parser.peek()
+	value, err := parser.parseValue(operator)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bare words are taken as the pattern itself rather than a field
+	compiled, err := regexp.Compile(valueText(value))
+	if err != nil {
+		return nil, parser.errorAt(start, "invalid regular expression: %v", err)
+	}
+
+	return &matchNode{left: left, pattern: compiled}, nil
+}
+
+// Parses the list of an IN comparison: '(' value { ',' value } ')'
+func (parser *parser) parseIn(operator token, left expression) (expression, error) {
+	open := parser.peek()
+	if open.kind != tokenLeftParen {
+		return nil, parser.errorAt(open, "expected '(' to start the list after IN, found %s", open.describe())
+	}
+	parser.next()
+
+	var values []expression
+	for {
+		value, err := parser.parseValue(operator)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		next := parser.next()
+		if next.kind == tokenRightParen {
+			break
+		}
+		if next.kind != tokenComma {
+			return nil, parser.errorAt(next, "expected ',' or ')' in the list after IN, found %s", next.describe())
+		}
+	}
+
+	return &inNode{left: left, values: values}, nil
+}
+
+// Parses the networks of an IN_CIDR comparison, either a single prefix or a list of them
+func (parser *parser) parseInCIDR(operator token, left expression) (expression, error) {
+	list := parser.peek().kind == tokenLeftParen
+	if list {
+		parser.next()
+	}
+
+	var prefixes []netip.Prefix
+	for {
+		start := parser.peek()
+		value, err := parser.parseValue(operator)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix, err := parsePrefix(valueText(value))
+		if err != nil {
+			return nil, parser.errorAt(start, "%v", err)
+		}
+		prefixes = append(prefixes, prefix)
+
+		if !list {
+			break
+		}
+		next := parser.next()
+		if next.kind == tokenRightParen {
+			break
+		}
+		if next.kind != tokenComma {
+			return nil, parser.errorAt(next, "expected ',' or ')' in the list after IN_CIDR, found %s", next.describe())
+		}
+	}
+
+	return &cidrNode{left: left, prefixes: prefixes}, nil
+}
+
+// Parses the bounds of a BETWEEN comparison: value AND value
+func (parser *parser) parseBetween(operator token, left expression) (expression, error) {
+	low, err := parser.parseValue(operator)
+	if err != nil {
+		return nil, err
+	}
+
+	and := parser.peek()
+	if !and.is("AND") {
+		return nil, parser.errorAt(and, "expected AND between the bounds of BETWEEN, found %s", and.describe())
+	}
+	parser.next()
+
+	high, err := parser.parseValue(and)
+	if err != nil {
+		return nil, err
+	}
+
+	return &betweenNode{left: left, low: low, high: high}, nil
+}
+
+// value := word | string | word word...
+// Consecutive bare words are merged back into one value, as PowerShell strips the quotes from
+// arguments such as "Subject == 'Urgent payment'"
+func (parser *parser) parseValue(operator token) (expression, error) {
+	first := parser.peek()
+	if first.kind != tokenWord && first.kind != tokenString || first.is("AND") || first.is("OR") {
+		return nil, parser.errorAt(first, "expected a value after %s, found %s", strings.ToUpper(operator.text), first.describe())
+	}
+
+	// A function call such as domain(Parameters.ForwardTo) or a calculation such as 12 * 3600
+	following := parser.tokens[parser.position+1]
+	if first.kind == tokenWord && !first.filled && following.kind == tokenLeftParen ||
+		numberPattern.MatchString(first.value) && (following.isArithmetic(arithmeticOperators[0]) || following.isArithmetic(arithmeticOperators[1])) {
+		return parser.parseArithmetic(true)
+	}
+	parser.next()
+
+	parts := []string{first.value}
+	quoted := first.kind == tokenString || first.filled
+	for {
+		next := parser.peek()
+		if next.kind == tokenComma && !operator.is("IN") && !operator.is("IN_CIDR") {
+			// Commas separate list values but are part of an unquoted value anywhere else, e.g. Subject == Hello, world
+			parts[len(parts)-1] += parser.next().value
+		} else if (next.kind == tokenWord || next.kind == tokenString) && !next.is("AND") && !next.is("OR") {
+			quoted = quoted || next.kind == tokenString || next.filled
+			parts = append(parts, parser.next().value)
+		} else {
+			break
+		}
+	}
+
+	value := strings.Join(parts, " ")
+
+	// Relative times such as -48h or now-2h, which are resolved when the query runs
+	// Quoted & filled in values are always literal, so Subject == 'Now' compares text
+	if !quoted && timerange.IsRelative(value) {
+		expression, err := timerange.Parse(value)
+		if err != nil {
+			return nil, parser.errorAt(first, "%v", err)
+		}
+		parser.clock.needsLatest = parser.clock.needsLatest || expression.NeedsLatest()
+		return &timeNode{expression: expression, clock: parser.clock}, nil
+	}
+
+	if len(parts) > 1 || parts[0] != first.value {
+		return &literalNode{value: value}, nil
+	}
+
+	return parser.parseTerm(first, true), nil
+}
+
+// Returns the text a value was written as, for operators that take a pattern or network rather than a value
+func valueText(value expression) string {
+	switch typed := value.(type) {
+	case *literalNode:
+		return typed.value
+	case *fieldNode:
+		return typed.path
+	case *timeNode:
+		return typed.expression.String()
+	}
+
+	return value.String()
+}
+
+// operand := product { ('+' | '-') product }
+// product := term { ('*' | '/') term }
+// Only the first term can be a field name without a literal fallback, as the rest are values being calculated with
+func (parser *parser) parseArithmetic(isValue bool) (expression, error) {
+	return parser.parseArithmeticLevel(len(arithmeticOperators)-1, isValue)
+}
+
+// Parses the operators at one level of precedence, with the tighter binding levels below it
+func (parser *parser) parseArithmeticLevel(level int, isValue bool) (expression, error) {
+	parseOperand := func(isValue bool) (expression, error) {
+		if level == 0 {
+			return parser.parseOperandTerm(isValue)
+		}
+		return parser.parseArithmeticLevel(level-1, isValue)
+	}
+
+	left, err := parseOperand(isValue)
+	if err != nil {
+		return nil, err
+	}
+
+	for parser.peek().isArithmetic(arithmeticOperators[level]) {
+		operator := parser.next()
+		if next := parser.peek(); next.kind != tokenWord && next.kind != tokenString || next.is("AND") || next.is("OR") {
+			return nil, parser.errorAt(next, "expected a value after %s, found %s", operator.text, next.describe())
+		}
+
+		right, err := parseOperand(true)
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmeticNode{operator: operator.text, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// term := word | string | call
+func (parser *parser) parseOperandTerm(isValue bool) (expression, error) {
+	term := parser.next()
+	if term.kind != tokenWord || term.filled || parser.peek().kind != tokenLeftParen {
+		return parser.parseTerm(term, isValue), nil
+	}
+
+	// call := name '(' [ operand { ',' operand } ] ')'
+	function, ok := functions[strings.ToLower(term.value)]
+	if !ok {
+		return nil, parser.errorAt(term, "unknown function '%s' (available: %s)", term.value, strings.Join(functionNames(), ", "))
+	}
+	open := parser.next()
+
+	var arguments []expression
+	if parser.peek().kind == tokenRightParen {
+		parser.next()
+	} else {
+		for {
+			if next := parser.peek(); next.kind != tokenWord && next.kind != tokenString {
+				return nil, parser.errorAt(next, "expected an argument to %s, found %s", term.value, next.describe())
+			}
+			// The first argument is the value being worked on, so like the left of a comparison it is always a field
+			argument, err := parser.parseArithmetic(len(arguments) > 0)
+			if err != nil {
+				return nil, err
+			}
+			arguments = append(arguments, argument)
+
+			next := parser.next()
+			if next.kind == tokenRightParen {
+				break
+			}
+			if next.kind != tokenComma {
+				return nil, parser.errorAt(next, "expected ',' or ')' in the arguments to %s, found %s", term.value, next.describe())
+			}
+		}
+	}
+
+	if len(arguments) != function.arguments {
+		return nil, parser.errorAt(open, "%s takes %d argument(s), found %d", term.value, function.arguments, len(arguments))
+	}
+
+	return &callNode{name: strings.ToLower(term.value), function: function, arguments: arguments}, nil
+}
+
+// Builds the node for a single word or string
+// Bare words that aren't fields are read as literals only when they are the value being compared
+// against (e.g. UserID == admin@contoso.com), so a misspelled field on the left resolves to nothing
+// Numbers are always literals, so they can be calculated with on either side of an arithmetic operator,
+// as are parameter values, so a value that happens to be a field name is still compared as text
+func (parser *parser) parseTerm(term token, isValue bool) expression {
+	if term.kind == tokenString || term.filled || numberPattern.MatchString(term.value) {
+		return &literalNode{value: term.value}
+	}
+
+	return &fieldNode{path: term.value, parts: strings.Split(term.value, "."), fallback: isValue}
+}
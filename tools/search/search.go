@@ -2,239 +2,202 @@ package search
 
 import (
 	// Standard library dependencies
+	"cmp"
 	"fmt"
+	"iter"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	// Internal dependencies
 	"CloudCutter/internal/logger"
+	"CloudCutter/internal/timerange"
 	"CloudCutter/models"
 )
 
-// Operators and their precedence
-var operators = map[string]int{
-	"OR":  1,
-	"AND": 2,
-	"==":  3, "!=": 3, ">": 3, ">=": 3, "<": 3, "<=": 3, "LIKE": 3,
+// Filter is a parsed query that events can be matched against
+type Filter struct {
+	root     expression // Nil when only keywords were given
+	clock    *clock
+	keywords *Keywords // Keywords from -k, any of which must appear in the event
+	report   *Keywords // Every keyword in the filter, to report the fields they matched
 }
 
-// Query the events with the given query
-func Query(events []models.PurviewEvent, query string) []models.PurviewEvent {
-	// Tokenise the query string into tokens
-	tokens := tokenise(query)
-	logger.Debugf("Found %d tokens", len(tokens))
+// Parse validates a query & builds the filter for it
+// Malformed queries return a *SyntaxError pointing at the problem
+func Parse(query string) (*Filter, error) {
+	return NewFilter(query, nil, Saved{})
+}
 
-	// Preprocess the tokens
-	tokens = preprocessTokens(tokens)
-	rpn := shunt(tokens)
-	logger.Debugf("RPN: %v", rpn)
+// Saved is a query from the library along with the values of its ${name} placeholders
+type Saved struct {
+	Query      string
+	Parameters map[string]string
+}
 
-	// Evaluate the RPN
-	var filteredEvents []models.PurviewEvent
+// NewFilter builds a filter from a query, keywords that are searched for in every value, or both
+// Events must match the query & contain at least one of the keywords, as well as matching the saved query if one is given
+// Only the saved query's placeholders are filled in, once it has been split into values,
+// so a parameter is always a single value whatever it contains
+func NewFilter(query string, keywords []string, saved Saved) (*Filter, error) {
+	filter := &Filter{clock: &clock{now: time.Now()}}
+
+	var terms []string
+	if saved.Query != "" {
+		root, savedKeywords, err := parse(saved.Query, filter.clock, saved.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		filter.root = root
+		terms = savedKeywords
+	}
 
-	// Loop through the events & evaluate the RPN
-	for _, event := range events {
-		if evaluate(rpn, event) {
-			filteredEvents = append(filteredEvents, event)
+	if strings.TrimSpace(query) != "" || len(keywords) == 0 && filter.root == nil {
+		root, queryKeywords, err := parse(query, filter.clock, nil)
+		if err != nil {
+			return nil, err
+		}
+		if filter.root != nil {
+			root = &logicalNode{operator: "AND", left: filter.root, right: root}
 		}
+		filter.root = root
+		terms = append(terms, queryKeywords...)
+	}
+	if filter.root != nil {
+		logger.Debugf("Parsed query: %s", filter.root)
 	}
 
-	return filteredEvents
+	if len(keywords) > 0 {
+		filter.keywords = NewKeywords(keywords)
+		terms = append(terms, keywords...)
+	}
+	if len(terms) > 0 {
+		filter.report = NewKeywords(terms)
+	}
+
+	return filter, nil
 }
 
-// Tokenise the query string into tokens
-func tokenise(query string) []string {
-	// Regex to match tokens: strings (single/double quoted), operators, parens, identifiers/numbers
-	expression := regexp.MustCompile(`"(?:\\.|[^"])*"|'(?:\\.|[^'])*'|>=|<=|==|!=|[><()]|[^\s()!><=]+`)
-	return expression.FindAllString(query, -1)
+// HasKeywords reports whether the filter searches for text in every value, so matching events note where it was found
+func (filter *Filter) HasKeywords() bool {
+	return filter.report != nil
 }
 
-// Preprocess the tokens to handle cases where PowerShell strips quotes around strings with spaces
-func preprocessTokens(tokens []string) []string {
-	// Store the processed tokens
-	var processed []string
-
-	for index := 0; index < len(tokens); index++ {
-		// Preprocess the token
-		token := tokens[index]
-		processed = append(processed, token)
-		upper := strings.ToUpper(token)
-
-		// Check if it is a comparison operator
-		if operatorKey, isOperator := operators[upper]; isOperator && operatorKey == 3 {
-			// Store the parts of the comparison
-			var parts []string
-
-			// Get the index of the next token
-			futureIndex := index + 1
-
-			// Loop through the tokens
-			for futureIndex < len(tokens) {
-				// Get the next token
-				next := tokens[futureIndex]
-				nextUpper := strings.ToUpper(next)
-
-				// Stop if logical operator or parenthesis is found
-				if next == "(" || next == ")" || nextUpper == "AND" || nextUpper == "OR" {
-					break
-				}
+// NeedsLatest reports whether the query has times relative to the newest event, such as -48h
+func (filter *Filter) NeedsLatest() bool {
+	return filter.clock.needsLatest
+}
 
-				// Add the next token to the parts array
-				parts = append(parts, next)
-				futureIndex++
-			}
+// SetLatest sets the newest event time that relative times are resolved against
+func (filter *Filter) SetLatest(latest time.Time) {
+	filter.clock.latest = latest
+}
 
-			// If there are multiple parts
-			if len(parts) > 1 {
-				// Merge them back together
-				merged := strings.Join(parts, " ")
-				processed = append(processed, merged)
-
-				// Skip the merged parts
-				index = futureIndex - 1
-			} else if len(parts) == 1 {
-				processed = append(processed, parts[0])
-				index = futureIndex - 1
-			}
-		}
+// Match checks whether a single event satisfies the query
+func (filter *Filter) Match(event models.PurviewEvent) bool {
+	if filter.root != nil && !isTrue(filter.root.evaluate(event)) {
+		return false
 	}
 
-	return processed
+	return filter.keywords == nil || filter.keywords.Match(event)
 }
 
-// Shunt the tokens into reverse polish notation
-func shunt(tokens []string) []string {
-	var output []string
-	var stack []string
-
-	for _, token := range tokens {
-		upperToken := strings.ToUpper(token)
-
-		// Handle the token
-		// Reorder the tokens to reverse polish notation
-		switch {
-		case isValue(token):
-			output = append(output, token)
-		case token == "(":
-			stack = append(stack, token)
-		case token == ")":
-			// Hunt for the matching parenthesis
-			for len(stack) > 0 && stack[len(stack)-1] != "(" {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-
-			// Remove the matching parenthesis
-			if len(stack) > 0 {
-				stack = stack[:len(stack)-1]
-			}
-		default:
-			// Default is an operator
-			operatorKey := token
-			if upperToken == "AND" || upperToken == "OR" {
-				operatorKey = upperToken
-			}
-
-			// Push the operator to the stack
-			for len(stack) > 0 && stack[len(stack)-1] != "(" && operators[stack[len(stack)-1]] >= operators[operatorKey] {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
+// Apply filters the events with the query
+// Events are filtered lazily as the returned sequence is consumed, with the fields any keywords were found in
+func (filter *Filter) Apply(events iter.Seq[models.PurviewEvent]) iter.Seq[models.PurviewEvent] {
+	return func(yield func(models.PurviewEvent) bool) {
+		for event := range events {
+			if filter.Match(event) {
+				if filter.report != nil {
+					event.MatchedFields, event.MatchedKeywords = filter.report.Find(event)
+				}
+				if !yield(event) {
+					return
+				}
 			}
-
-			// Add the operator to the stack
-			stack = append(stack, operatorKey)
 		}
 	}
+}
 
-	// Add any remaining operators to the output
-	for len(stack) > 0 {
-		output = append(output, stack[len(stack)-1])
-		stack = stack[:len(stack)-1]
+// Placeholders returns the names of the ${name} placeholders in a query, in the order they first appear
+func Placeholders(query string) []string {
+	var names []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(query, -1) {
+		if !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
 	}
 
-	return output
+	return names
 }
 
-// Check if the token is a value, not an operator or parenthesis
-func isValue(token string) bool {
-	upperToken := strings.ToUpper(token)
-	_, isOperator := operators[upperToken]
-	_, isOperatorOrig := operators[token]
-	return !isOperator && !isOperatorOrig && token != "(" && token != ")"
+// Resolve looks up a field path such as UserID, Emails.Subject or AppAccessContext.AADSessionId
+// the same way queries do, returning nil if it doesn't exist. Paths into arrays return every match
+func Resolve(event models.PurviewEvent, path string) any {
+	return resolveField(strings.Split(path, "."), event)
 }
 
-// Evaluate the reverse polish notation
-func evaluate(rpn []string, event models.PurviewEvent) bool {
-	// Must be []any to hold both strings (from resolve) and bools (from compute)
-	var stack []any
-
-	// Evaluate the RPN
-	for _, token := range rpn {
-		if isValue(token) {
-			// Push the value to the stack
-			stack = append(stack, resolveValue(token, event))
-		} else {
-			// If there are not enough values on the stack, return false
-			if len(stack) < 2 {
-				return false
-			}
-
-			// Pop the top two values from the stack
-			right := stack[len(stack)-1]
-			left := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
-
-			// Compute the result
-			result := compute(left, token, right)
-			logger.Debugf("Compute: %v %s %v -> %v", left, token, right, result)
-			stack = append(stack, result)
-		}
+// Compare orders two values chronologically or numerically when both can be read that way,
+// & as case-insensitive text otherwise
+func Compare(left any, right any) int {
+	sLeft := ""
+	if left != nil {
+		sLeft = strings.TrimSpace(fmt.Sprintf("%v", left))
 	}
-
-	// If there is not exactly one value on the stack, return false
-	if len(stack) != 1 {
-		return false
+	sRight := ""
+	if right != nil {
+		sRight = strings.TrimSpace(fmt.Sprintf("%v", right))
 	}
 
-	// Return the result
-	result, isBool := stack[0].(bool)
+	if tLeft, okL := tryParseTime(sLeft); okL {
+		if tRight, okR := tryParseTime(sRight); okR {
+			return tLeft.Compare(tRight)
+		}
+	}
 
-	if !isBool {
-		return false
+	lVal, errL := strconv.ParseFloat(sLeft, 64)
+	rVal, errR := strconv.ParseFloat(sRight, 64)
+	if errL == nil && errR == nil {
+		return cmp.Compare(lVal, rVal)
 	}
 
-	return result
+	return cmp.Compare(strings.ToLower(sLeft), strings.ToLower(sRight))
 }
 
-// Resolve the value of a token
-func resolveValue(token string, event models.PurviewEvent) any {
-	// Strip the token of quotes
-	cleanToken := strings.Trim(token, "\"'")
-
-	// If the token is a string, return it
-	if strings.HasPrefix(token, "'") || strings.HasPrefix(token, "\"") {
-		return cleanToken
-	}
+// Resolve a field path against the event, returning nil if it doesn't exist
+func resolveField(parts []string, event models.PurviewEvent) any {
+	value, _ := lookupField(parts, event)
+	return value
+}
 
-	// Split the token into parts
-	parts := strings.Split(cleanToken, ".")
+// Look up a field path on the struct, the Flattened map or AuditData & report whether it holds a value
+// Empty struct fields & null AuditData values don't count, though an empty struct field is still returned
+func lookupField(parts []string, event models.PurviewEvent) (any, bool) {
+	path := strings.Join(parts, ".")
 
 	// Try resolving via struct fields
 	res := resolveRecursive(parts, reflect.ValueOf(event))
-	if res != nil {
-		logger.Debugf("Resolved '%s' via struct fields to '%v'", token, res)
-		return res
+	if res != nil && !reflect.ValueOf(res).IsZero() {
+		logger.Debugf("Resolved '%s' via struct fields to '%v'", path, res)
+		return res, true
+	}
+	structValue := res
+
+	// Try resolving via Flattened map (exact dotted path, e.g. AppAccessContext.AADSessionId)
+	if val, ok := event.Flattened[strings.ToLower(path)]; ok && val != nil && len(parts) > 1 {
+		logger.Debugf("Resolved '%s' via Flattened path to '%v'", path, val)
+		return val, true
 	}
 
 	// Try resolving via Flattened map (top level match)
 	if val, ok := event.Flattened[strings.ToLower(parts[0])]; ok {
 		res = resolveRecursive(parts[1:], reflect.ValueOf(val))
 		if res != nil {
-			logger.Debugf("Resolved '%s' via Flattened map to '%v'", token, res)
-			return res
+			logger.Debugf("Resolved '%s' via Flattened map to '%v'", path, res)
+			return res, true
 		}
 	}
 
@@ -243,17 +206,12 @@ func resolveValue(token string, event models.PurviewEvent) any {
 		if strings.EqualFold(k, parts[0]) {
 			res = resolveRecursive(parts[1:], reflect.ValueOf(v))
 			if res != nil {
-				return res
+				return res, true
 			}
 		}
 	}
 
-	// Fallback for single parts that aren't fields: treat as literal
-	if len(parts) == 1 {
-		return cleanToken
-	}
-
-	return nil
+	return structValue, false
 }
 
 func resolveRecursive(parts []string, val reflect.Value) any {
@@ -315,6 +273,11 @@ func resolveRecursive(parts []string, val reflect.Value) any {
 // Compute the result of an operation
 // This is a bit of a mess, but it works
 func compute(left any, op string, right any) bool {
+	// Nothing compares with a missing field, so a misspelled field never matches (use IS NULL to find missing fields)
+	if left == nil || right == nil {
+		return false
+	}
+
 	// If left is a slice, perform "any" logic
 	if left != nil && reflect.TypeOf(left).Kind() == reflect.Slice {
 		v := reflect.ValueOf(left)
@@ -326,16 +289,6 @@ func compute(left any, op string, right any) bool {
 		return false
 	}
 
-	// If it's a logical operation, we expect booleans
-	if op == "AND" || op == "OR" {
-		lBool, _ := left.(bool)
-		rBool, _ := right.(bool)
-		if op == "AND" {
-			return lBool && rBool
-		}
-		return lBool || rBool
-	}
-
 	// fmt.Printf("DEBUG: compare '%v' %s '%v'\n", left, op, right)
 
 	// For comparisons, we expect strings/numbers
@@ -423,6 +376,57 @@ func compute(left any, op string, right any) bool {
 	return false
 }
 
+// Calculate the result of an arithmetic operator, element by element for arrays
+// Subtracting one time from another gives the seconds between them, & anything that isn't a number gives nil
+func calculate(left any, operator string, right any) any {
+	if right != nil && reflect.TypeOf(right).Kind() == reflect.Slice {
+		return eachElement(right, func(element any) any {
+			return calculate(left, operator, element)
+		})
+	}
+	if left != nil && reflect.TypeOf(left).Kind() == reflect.Slice {
+		return eachElement(left, func(element any) any {
+			return calculate(element, operator, right)
+		})
+	}
+	if left == nil || right == nil {
+		return nil
+	}
+
+	sLeft := strings.TrimSpace(fmt.Sprintf("%v", left))
+	sRight := strings.TrimSpace(fmt.Sprintf("%v", right))
+
+	if operator == "-" {
+		if tLeft, okL := tryParseTime(sLeft); okL {
+			if tRight, okR := tryParseTime(sRight); okR {
+				return tLeft.Sub(tRight).Seconds()
+			}
+		}
+	}
+
+	lVal, errL := strconv.ParseFloat(sLeft, 64)
+	rVal, errR := strconv.ParseFloat(sRight, 64)
+	if errL != nil || errR != nil {
+		return nil
+	}
+
+	switch operator {
+	case "+":
+		return lVal + rVal
+	case "-":
+		return lVal - rVal
+	case "*":
+		return lVal * rVal
+	case "/":
+		if rVal == 0 {
+			return nil
+		}
+		return lVal / rVal
+	}
+
+	return nil
+}
+
 // tryParseTime attempts to parse a string into a time.Time using common formats
 func tryParseTime(s string) (time.Time, bool) {
 	formats := []string{
@@ -430,10 +434,11 @@ func tryParseTime(s string) (time.Time, bool) {
 		"15:04:05",            // Time
 		time.RFC3339,          // RFC3339
 		"2006-01-02T15:04:05", // Combined fallback
+		"2006-01-02 15:04:05", // Combined with a space, as --from & --to accept
 	}
 
 	for _, f := range formats {
-		if t, err := time.Parse(f, s); err == nil {
+		if t, err := time.ParseInLocation(f, s, timerange.Location); err == nil {
 			return t, true
 		}
 	}
@@ -0,0 +1,157 @@
+package sequence
+
+import (
+	// Standard library dependencies
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/format"
+	"CloudCutter/internal/logger"
+	"CloudCutter/models"
+	"CloudCutter/tools/search"
+)
+
+// Options describe the ordered steps of a sequence & how their events are tied together
+type Options struct {
+	Steps  []*search.Filter // Each step's query, in the order the events must happen
+	Join   []string         // Fields every event in a chain must share, e.g. UserID or ClientIP
+	Within time.Duration    // Longest time from the first step to the last
+}
+
+// Chain is one occurrence of the sequence, with an event for each step
+type Chain struct {
+	Join   []string // Value of each join field shared by the events
+	Events []models.PurviewEvent
+	Start  time.Time
+	End    time.Time
+}
+
+// A step event with its parsed time & join key
+type candidate struct {
+	event     models.PurviewEvent
+	timestamp time.Time
+	key       string
+	join      []string
+	steps     []int // Steps the event matches
+}
+
+// Find returns every chain of events that match the steps in order, share the join fields
+// & happen within the time limit, ordered by when they completed
+// Only events matching a step are kept, as exports may be read newest first & have to be put in time order
+func Find(events iter.Seq[models.PurviewEvent], options Options) []Chain {
+	var candidates []candidate
+	for event := range events {
+		var steps []int
+		for index, step := range options.Steps {
+			if step.Match(event) {
+				steps = append(steps, index)
+			}
+		}
+		if len(steps) == 0 {
+			continue
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+		if err != nil {
+			logger.Debugf("Skipping event %s for sequences as it has no timestamp", event.RecordID)
+			continue
+		}
+
+		// Events missing a join field can't be tied to any others
+		join, ok := joinValues(event, options.Join)
+		if !ok {
+			continue
+		}
+
+		candidates = append(candidates, candidate{
+			event:     event,
+			timestamp: timestamp,
+			key:       strings.ToLower(strings.Join(join, "\x00")),
+			join:      join,
+			steps:     steps,
+		})
+	}
+	logger.Debugf("Found %d events matching a sequence step", len(candidates))
+
+	slices.SortStableFunc(candidates, func(left, right candidate) int {
+		return left.timestamp.Compare(right.timestamp)
+	})
+
+	// For each join key, the most recent partial chain to have reached each step
+	// Keeping the latest start gives the shortest chain & the best chance of fitting the time limit
+	partials := make(map[string][]*Chain)
+	var chains []Chain
+
+	last := len(options.Steps) - 1
+	for _, current := range candidates {
+		stages, ok := partials[current.key]
+		if !ok {
+			stages = make([]*Chain, len(options.Steps))
+			partials[current.key] = stages
+		}
+
+		// Later steps first, so an event matching several steps can't follow itself
+		for index := last; index >= 0; index-- {
+			if !slices.Contains(current.steps, index) {
+				continue
+			}
+
+			if index == 0 {
+				stages[0] = &Chain{
+					Join:   current.join,
+					Events: []models.PurviewEvent{current.event},
+					Start:  current.timestamp,
+					End:    current.timestamp,
+				}
+				continue
+			}
+
+			previous := stages[index-1]
+			if previous == nil {
+				continue
+			}
+			if options.Within > 0 && current.timestamp.Sub(previous.Start) > options.Within {
+				stages[index-1] = nil
+				continue
+			}
+
+			extended := &Chain{
+				Join:   previous.Join,
+				Events: append(slices.Clone(previous.Events), current.event),
+				Start:  previous.Start,
+				End:    current.timestamp,
+			}
+			stages[index-1] = nil
+
+			if index == last {
+				chains = append(chains, *extended)
+			} else {
+				stages[index] = extended
+			}
+		}
+
+		// A single step sequence completes on every match
+		if last == 0 && stages[0] != nil {
+			chains = append(chains, *stages[0])
+			stages[0] = nil
+		}
+	}
+
+	return chains
+}
+
+// Reads the join fields of an event, reporting false if any are missing
+func joinValues(event models.PurviewEvent, fields []string) ([]string, bool) {
+	values := make([]string, len(fields))
+	for index, field := range fields {
+		values[index] = format.FormatValue(search.Resolve(event, field))
+		if values[index] == "" {
+			return nil, false
+		}
+	}
+
+	return values, true
+}
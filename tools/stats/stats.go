@@ -0,0 +1,131 @@
+package stats
+
+import (
+	// Standard library dependencies
+	"cmp"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/format"
+	"CloudCutter/models"
+	"CloudCutter/tools/search"
+)
+
+// Options control how events are grouped
+type Options struct {
+	By            []string      // Fields to group by
+	Bucket        time.Duration // Width of the time buckets to group by, 0 for none
+	CountDistinct string        // Field to count the distinct values of in each group
+}
+
+// Group holds the statistics of one combination of group-by values
+type Group struct {
+	Values    []string  // Value of each group-by field
+	Bucket    time.Time // Start of the time bucket, zero when not grouping by time
+	Count     int       // Number of events
+	Distinct  int       // Number of distinct values of the CountDistinct field
+	FirstSeen time.Time // Earliest event
+	LastSeen  time.Time // Latest event
+
+	distinct map[string]bool
+}
+
+// Aggregate groups the events & returns each group, in time bucket order & largest first
+// A path into an array (e.g. Emails.Subject) puts the event in a group for each of its values
+func Aggregate(events iter.Seq[models.PurviewEvent], options Options) []*Group {
+	var groups []*Group
+	seen := make(map[string]*Group)
+
+	for event := range events {
+		timestamp, hasTime := parseTimestamp(event)
+
+		var bucket time.Time
+		if options.Bucket > 0 && hasTime {
+			bucket = truncate(timestamp, options.Bucket)
+		}
+
+		for _, values := range Combinations(event, options.By) {
+			key := strings.Join(values, "\x00") + "\x00" + bucket.String()
+			group, ok := seen[key]
+			if !ok {
+				group = &Group{Values: values, Bucket: bucket, distinct: make(map[string]bool)}
+				seen[key] = group
+				groups = append(groups, group)
+			}
+
+			group.Count++
+			if hasTime {
+				if group.FirstSeen.IsZero() || timestamp.Before(group.FirstSeen) {
+					group.FirstSeen = timestamp
+				}
+				if timestamp.After(group.LastSeen) {
+					group.LastSeen = timestamp
+				}
+			}
+
+			if options.CountDistinct != "" {
+				for _, combination := range Combinations(event, []string{options.CountDistinct}) {
+					if combination[0] != "" {
+						group.distinct[combination[0]] = true
+					}
+				}
+				group.Distinct = len(group.distinct)
+			}
+		}
+	}
+
+	// Time buckets read as a timeline, with the largest groups first within each bucket
+	slices.SortStableFunc(groups, func(left, right *Group) int {
+		if result := left.Bucket.Compare(right.Bucket); result != 0 {
+			return result
+		}
+		return cmp.Compare(right.Count, left.Count)
+	})
+
+	return groups
+}
+
+// Combinations returns every combination of the fields' values for an event, formatted as text
+// Each value of a path into an array makes a separate combination
+func Combinations(event models.PurviewEvent, fields []string) [][]string {
+	combinations := [][]string{nil}
+
+	for _, field := range fields {
+		value := search.Resolve(event, field)
+		values := []string{format.FormatValue(value)}
+		if elements, ok := value.([]any); ok && len(elements) > 0 {
+			values = values[:0]
+			for _, element := range elements {
+				values = append(values, format.FormatValue(element))
+			}
+		}
+
+		var expanded [][]string
+		for _, combination := range combinations {
+			for _, value := range values {
+				expanded = append(expanded, append(slices.Clone(combination), value))
+			}
+		}
+		combinations = expanded
+	}
+
+	return combinations
+}
+
+// Reads an event's timestamp, which is in the display timezone once events are localised
+func parseTimestamp(event models.PurviewEvent) (time.Time, bool) {
+	timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+	return timestamp, err == nil
+}
+
+// Rounds a time down to the start of its bucket
+// Buckets are aligned to the time's own zone, so daily buckets start at local midnight
+func truncate(timestamp time.Time, bucket time.Duration) time.Time {
+	_, offset := timestamp.Zone()
+	shift := time.Duration(offset) * time.Second
+
+	return timestamp.Add(shift).Truncate(bucket).Add(-shift)
+}
@@ -0,0 +1,215 @@
+package surrounding
+
+import (
+	// Standard library dependencies
+	"fmt"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	// Internal dependencies
+	"CloudCutter/internal/logger"
+	"CloudCutter/internal/timerange"
+	"CloudCutter/models"
+)
+
+// Span is how much context to include on one side of a hit, as a length of time or a number of events
+type Span struct {
+	Duration time.Duration
+	Count    int
+}
+
+// ParseSpan reads a span written as a duration (30m, 2h, 1d) or a number of events (5)
+func ParseSpan(text string) (Span, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Span{}, nil
+	}
+
+	if count, err := strconv.Atoi(text); err == nil {
+		if count < 0 {
+			return Span{}, fmt.Errorf("invalid context '%s': must not be negative", text)
+		}
+		return Span{Count: count}, nil
+	}
+
+	duration, err := timerange.ParseDuration(text)
+	if err != nil {
+		return Span{}, fmt.Errorf("invalid context '%s' (expected a number of events or a duration such as 30m, 2h or 1d)", text)
+	}
+
+	return Span{Duration: duration}, nil
+}
+
+// IsZero reports whether the span includes no context
+func (span Span) IsZero() bool {
+	return span.Duration == 0 && span.Count == 0
+}
+
+// Options control which events are pulled in around each hit
+type Options struct {
+	Before Span
+	After  Span
+	SameIP bool // Only include events from the hit's ClientIP as well as its UserID
+	Limit  int  // Stop after this many hits, 0 for all
+}
+
+// Enabled reports whether any context was asked for
+func (options Options) Enabled() bool {
+	return !options.Before.IsZero() || !options.After.IsZero()
+}
+
+// An event with its parsed time
+type timedEvent struct {
+	event     models.PurviewEvent
+	timestamp time.Time
+	sequence  int // Position in the events read for context, which orders events at the same time
+}
+
+// Orders events by time, & by where they were read for events at the same time
+func compareTimed(left, right timedEvent) int {
+	if order := left.timestamp.Compare(right.timestamp); order != 0 {
+		return order
+	}
+	return left.sequence - right.sequence
+}
+
+// A hit & the events around it that its spans can still include
+type window struct {
+	hit    timedEvent
+	passed bool         // Whether the hit itself has been read, so events at the same time come after it
+	before []timedEvent // Candidates before the hit, in time order
+	after  []timedEvent // Candidates after the hit, in time order
+}
+
+// Add returns the hits along with the events around each of them for the same user, in time order
+// Context events have ContextOf set to the RecordID of the hit they surround & every hit is kept as it is
+// The hits are read first, then events reads the exports again, keeping only the events each hit's spans can include
+func Add(hits iter.Seq[models.PurviewEvent], events iter.Seq[models.PurviewEvent], options Options) iter.Seq[models.PurviewEvent] {
+	return func(yield func(models.PurviewEvent) bool) {
+		var matched []timedEvent
+		hitIDs := make(map[string]bool)
+
+		for hit := range hits {
+			timestamp, _ := time.Parse(time.RFC3339, hit.Timestamp)
+			matched = append(matched, timedEvent{event: hit, timestamp: timestamp})
+			hitIDs[hit.RecordID] = true
+
+			if options.Limit > 0 && len(matched) >= options.Limit {
+				break
+			}
+		}
+
+		// Earlier hits claim the context they share with later ones
+		slices.SortStableFunc(matched, func(left, right timedEvent) int {
+			return left.timestamp.Compare(right.timestamp)
+		})
+
+		var windows []*window
+		byKey := make(map[string][]*window)
+		for _, hit := range matched {
+			if key := options.key(hit.event); key != "" {
+				hitWindow := &window{hit: hit}
+				windows = append(windows, hitWindow)
+				byKey[key] = append(byKey[key], hitWindow)
+			}
+		}
+
+		// Only the events within a span of a hit are kept, however much else the user did
+		if len(byKey) > 0 {
+			sequence := 0
+			for event := range events {
+				keyWindows := byKey[options.key(event)]
+				if len(keyWindows) == 0 {
+					continue
+				}
+				timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+				if err != nil {
+					continue
+				}
+				sequence++
+				candidate := timedEvent{event: event, timestamp: timestamp, sequence: sequence}
+
+				for _, hitWindow := range keyWindows {
+					hit := hitWindow.hit
+					if event.RecordID != "" && event.RecordID == hit.event.RecordID {
+						hitWindow.passed = true
+						continue
+					}
+
+					if timestamp.Before(hit.timestamp) || timestamp.Equal(hit.timestamp) && !hitWindow.passed {
+						hitWindow.before = options.Before.keep(hitWindow.before, candidate, hit.timestamp.Sub(timestamp), true)
+					} else {
+						hitWindow.after = options.After.keep(hitWindow.after, candidate, timestamp.Sub(hit.timestamp), false)
+					}
+				}
+			}
+		}
+
+		results := slices.Clone(matched)
+		claimed := make(map[string]bool)
+		for _, hitWindow := range windows {
+			for _, context := range slices.Concat(hitWindow.before, hitWindow.after) {
+				id := context.event.RecordID
+				if hitIDs[id] || claimed[id] {
+					continue
+				}
+				claimed[id] = true
+
+				context.event.ContextOf = hitWindow.hit.event.RecordID
+				results = append(results, context)
+			}
+		}
+		logger.Debugf("Added %d context events around %d hits", len(results)-len(matched), len(matched))
+
+		slices.SortStableFunc(results, func(left, right timedEvent) int {
+			return left.timestamp.Compare(right.timestamp)
+		})
+
+		for _, result := range results {
+			if !yield(result.event) {
+				return
+			}
+		}
+	}
+}
+
+// Adds an event a gap away from a hit to the candidates on one side of it, if the span can include it
+// A count keeps only the nearest events, dropping the furthest from the start of the candidates before a hit
+// or from the end of those after it
+func (span Span) keep(candidates []timedEvent, candidate timedEvent, gap time.Duration, before bool) []timedEvent {
+	switch {
+	case span.Count > 0:
+		position, _ := slices.BinarySearchFunc(candidates, candidate, compareTimed)
+		candidates = slices.Insert(candidates, position, candidate)
+		if len(candidates) > span.Count {
+			if before {
+				candidates = slices.Delete(candidates, 0, 1)
+			} else {
+				candidates = candidates[:span.Count]
+			}
+		}
+	case span.Duration > 0 && gap <= span.Duration:
+		position, _ := slices.BinarySearchFunc(candidates, candidate, compareTimed)
+		candidates = slices.Insert(candidates, position, candidate)
+	}
+
+	return candidates
+}
+
+// Groups events by user, & by IP address as well when SameIP is set
+// Events without a user can't have context
+func (options Options) key(event models.PurviewEvent) string {
+	if event.UserID == "" {
+		return ""
+	}
+
+	key := strings.ToLower(event.UserID)
+	if options.SameIP {
+		key += "\x00" + strings.ToLower(event.ClientIP)
+	}
+
+	return key
+}